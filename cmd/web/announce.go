@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"github.com/seemsod1/ancy/internal/handlers"
+	"time"
+)
+
+// announceExhibits notifies saved searches of scheduled exhibits once their
+// publishing window opens, checking every interval
+func announceExhibits(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		count, err := handlers.Repo.AnnounceExhibits(context.Background())
+		if err != nil {
+			app.ErrorLog.Println("announcing exhibits failed:", err)
+			continue
+		}
+		if count > 0 {
+			app.InfoLog.Printf("announced %d exhibits to saved searches", count)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// gcMinAge is how old an unreferenced file must be before it is collected
+const gcMinAge = 24 * time.Hour
+
+// runGC implements the "gc" subcommand, which reports or deletes stored
+// files that no exhibit or user references
+func runGC(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", true, "only report orphaned files, set to false to delete them")
+	minAge := flags.Duration("min-age", gcMinAge, "skip files modified more recently than this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := app.Assets.CollectGarbage(context.Background(), *dryRun, *minAge)
+	if err != nil {
+		return err
+	}
+
+	for _, orphan := range report.Orphans {
+		fmt.Printf("%s\t%d\t%s\n", orphan.Key, orphan.Size, orphan.ModTime.Format(time.RFC3339))
+	}
+	fmt.Printf("scanned %d files, %d orphaned (%d bytes), %d deleted\n",
+		report.Scanned, len(report.Orphans), report.Bytes, report.Deleted)
+
+	return nil
+}
+
+// collectGarbage deletes orphaned files every interval
+func collectGarbage(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		report, err := app.Assets.CollectGarbage(context.Background(), false, gcMinAge)
+		if err != nil {
+			app.ErrorLog.Println("storage gc failed:", err)
+			continue
+		}
+		if report.Deleted > 0 {
+			app.InfoLog.Printf("storage gc deleted %d orphaned files (%d bytes)", report.Deleted, report.Bytes)
+		}
+	}
+}
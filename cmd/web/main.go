@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"github.com/alexedwards/scs/v2"
 	"github.com/seemsod1/ancy/internal/config"
 	"log"
 	"net/http"
+	"os"
+	"time"
 )
 
 const portNumber = ":8080"
@@ -18,6 +21,20 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		if err := runGC(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	go app.Uploads.Run(context.Background(), time.Hour, app.ErrorLog)
+	if app.Env.StorageGCInterval > 0 {
+		go collectGarbage(app.Env.StorageGCInterval)
+	}
+	go purgeTrash(time.Hour, app.Env.TrashRetention)
+	go announceExhibits(time.Minute)
+
 	srv := &http.Server{
 		Addr:    portNumber,
 		Handler: routes(&app),
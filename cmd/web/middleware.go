@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/justinas/nosurf"
+	"github.com/seemsod1/ancy/internal/models"
 	"net/http"
 )
 
@@ -40,13 +41,26 @@ func SessionLoad(next http.Handler) http.Handler {
 	return app.Session.LoadAndSave(next)
 }
 
+// AuthUser lets through signed in users. Sessions of users moved to the
+// trash since they signed in are ended.
 func AuthUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, ok := app.Session.Get(r.Context(), "user_id").(int)
+		userID, ok := app.Session.Get(r.Context(), "user_id").(int)
 		if !ok {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+
+		var count int64
+		if err := app.DB.Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if count == 0 {
+			_ = app.Session.Destroy(r.Context())
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexedwards/scs/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthUser(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	app.DB, err = gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Session = scs.New()
+
+	tests := []struct {
+		name string
+		// users is how many users not in the trash have the ID of the session
+		users      int
+		signedIn   bool
+		wantStatus int
+		wantUser   bool
+	}{
+		{"guest", 0, false, http.StatusUnauthorized, false},
+		{"signed in", 1, true, http.StatusOK, true},
+		{"moved to the trash", 0, true, http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		if tt.signedIn {
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE id = \$1 AND "users"."deleted_at" IS NULL`).
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.users))
+		}
+
+		var hasUser bool
+		handler := app.Session.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.signedIn {
+				app.Session.Put(r.Context(), "user_id", 7)
+			}
+			AuthUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			hasUser = app.Session.Exists(r.Context(), "user_id")
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/user/exhibits", nil))
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if hasUser != tt.wantUser {
+			t.Errorf("%s: session keeps the user = %v, want %v", tt.name, hasUser, tt.wantUser)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
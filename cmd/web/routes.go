@@ -22,10 +22,38 @@ func routes(app *config.AppConfig) http.Handler {
 		authRouter.Use(AuthUser)
 
 		authRouter.Post("/logout", handlers.Repo.Logout)
-		authRouter.Post("/exhibit/create", handlers.Repo.CreateExhibit)        // Зареєстровані користувачі
-		authRouter.Delete("/exhibit/delete/{id}", handlers.Repo.DeleteExhibit) // Зареєстровані користувачі
-		authRouter.Get("/exhibit/my", handlers.Repo.GetMyExhibits)             // Зареєстровані користувачі
-		authRouter.Patch("/me/update-photo", handlers.Repo.UpdatePhoto)        // Зареєстровані користувачі
+		authRouter.Post("/exhibit/create", handlers.Repo.CreateExhibit)                           // Зареєстровані користувачі
+		authRouter.Delete("/exhibit/delete/{id}", handlers.Repo.DeleteExhibit)                    // Зареєстровані користувачі
+		authRouter.Patch("/exhibit/{id}", handlers.Repo.UpdateExhibit)                            // Зареєстровані користувачі
+		authRouter.Get("/exhibit/{id}/revisions", handlers.Repo.GetExhibitRevisions)              // Зареєстровані користувачі
+		authRouter.Post("/exhibit/{id}/transition/{transition}", handlers.Repo.TransitionExhibit) // Зареєстровані користувачі
+		authRouter.Get("/exhibit/my", handlers.Repo.GetMyExhibits)                                // Зареєстровані користувачі
+		authRouter.Patch("/me/update-photo", handlers.Repo.UpdatePhoto)                           // Зареєстровані користувачі
+
+		// Колекції
+		authRouter.Route("/collections", func(r chi.Router) {
+			r.Post("/", handlers.Repo.CreateCollection)                  // Зареєстровані користувачі
+			r.Put("/{id}", handlers.Repo.UpdateCollection)               // Зареєстровані користувачі
+			r.Delete("/{id}", handlers.Repo.DeleteCollection)            // Зареєстровані користувачі
+			r.Put("/{id}/exhibits", handlers.Repo.SetCollectionExhibits) // Зареєстровані користувачі
+		})
+
+		// Збережені пошуки та сповіщення
+		authRouter.Route("/saved-searches", func(r chi.Router) {
+			r.Post("/", handlers.Repo.CreateSavedSearch)       // Зареєстровані користувачі
+			r.Delete("/{id}", handlers.Repo.DeleteSavedSearch) // Зареєстровані користувачі
+		})
+		authRouter.Put("/notifications/{id}/read", handlers.Repo.ReadNotification) // Зареєстровані користувачі
+
+		// Роути для завантаження великих файлів частинами (tus)
+		authRouter.Route("/uploads", func(r chi.Router) {
+			r.Options("/", handlers.Repo.UploadOptions)            // Зареєстровані користувачі
+			r.Post("/", handlers.Repo.CreateUpload)                // Зареєстровані користувачі
+			r.Head("/{id}", handlers.Repo.UploadStatus)            // Зареєстровані користувачі
+			r.Patch("/{id}", handlers.Repo.UploadChunk)            // Зареєстровані користувачі
+			r.Delete("/{id}", handlers.Repo.DeleteUpload)          // Зареєстровані користувачі
+			r.Post("/{id}/finalize", handlers.Repo.FinalizeUpload) // Зареєстровані користувачі
+		})
 
 		// Роутер для адміністратора
 		adminRouter := chi.NewRouter()
@@ -41,28 +69,70 @@ func routes(app *config.AppConfig) http.Handler {
 			r.Delete("/delete/{id}", handlers.Repo.DeleteUserRole) // Тільки для адміна
 		})
 
-		adminRouter.Post("/exhibit/approve/{id}", handlers.Repo.ApproveExhibit) // Тільки для адміна
-		adminRouter.Post("/exhibit/reject/{id}", handlers.Repo.RejectExhibit)   // Тільки для адміна
-		adminRouter.Get("/users/all", handlers.Repo.GetAllUsers)                // Тільки для адміна
-		adminRouter.Post("/make-admin/{id}", handlers.Repo.MakeAdmin)           // Тільки для адміна
-		adminRouter.Post("/remove-admin/{id}", handlers.Repo.RemoveAdmin)       // Тільки для адміна
-		adminRouter.Delete("/user/delete/{id}", handlers.Repo.DeleteUser)       // Тільки для адміна
+		adminRouter.Post("/exhibit/approve/{id}", handlers.Repo.ApproveExhibit)              // Тільки для адміна
+		adminRouter.Post("/exhibit/reject/{id}", handlers.Repo.RejectExhibit)                // Тільки для адміна
+		adminRouter.Post("/exhibit/{id}/rollback/{revision}", handlers.Repo.RollbackExhibit) // Тільки для адміна
+		adminRouter.Put("/exhibit/{id}/schedule", handlers.Repo.ScheduleExhibit)             // Тільки для адміна
+
+		// Черга модерації
+		adminRouter.Route("/moderation", func(r chi.Router) {
+			r.Get("/queue", handlers.Repo.ModerationQueue)        // Тільки для адміна
+			r.Post("/{id}/claim", handlers.Repo.ClaimExhibit)     // Тільки для адміна
+			r.Delete("/{id}/claim", handlers.Repo.UnclaimExhibit) // Тільки для адміна
+			r.Post("/batch", handlers.Repo.BatchModerate)         // Тільки для адміна
+		})
+
+		// Кошик
+		adminRouter.Route("/trash", func(r chi.Router) {
+			r.Get("/exhibits", handlers.Repo.GetTrashedExhibits)          // Тільки для адміна
+			r.Get("/users", handlers.Repo.GetTrashedUsers)                // Тільки для адміна
+			r.Post("/exhibit/{id}/restore", handlers.Repo.RestoreExhibit) // Тільки для адміна
+			r.Post("/user/{id}/restore", handlers.Repo.RestoreUser)       // Тільки для адміна
+		})
+
+		// Типи експонатів
+		adminRouter.Route("/exhibit-types", func(r chi.Router) {
+			r.Post("/", handlers.Repo.CreateExhibitType)       // Тільки для адміна
+			r.Put("/{id}", handlers.Repo.UpdateExhibitType)    // Тільки для адміна
+			r.Delete("/{id}", handlers.Repo.DeleteExhibitType) // Тільки для адміна
+		})
+
+		// Теги
+		adminRouter.Route("/tags", func(r chi.Router) {
+			r.Get("/", handlers.Repo.GetAllTags)                   // Тільки для адміна
+			r.Put("/{id}", handlers.Repo.RenameTag)                // Тільки для адміна
+			r.Post("/{id}/merge/{target}", handlers.Repo.MergeTag) // Тільки для адміна
+		})
+
+		adminRouter.Get("/users/all", handlers.Repo.GetAllUsers)          // Тільки для адміна
+		adminRouter.Post("/make-admin/{id}", handlers.Repo.MakeAdmin)     // Тільки для адміна
+		adminRouter.Post("/remove-admin/{id}", handlers.Repo.RemoveAdmin) // Тільки для адміна
+		adminRouter.Delete("/user/delete/{id}", handlers.Repo.DeleteUser) // Тільки для адміна
 
 		mux.Mount("/user", authRouter)   // Встановлюємо роутер для залогінених користувачів
 		mux.Mount("/admin", adminRouter) // Встановлюємо роутер для адміністратора
 
 		// Роутер для гостя
-		mux.Post("/login", handlers.Repo.Login)            // Гість
-		mux.Post("/sign-up", handlers.Repo.SignUp)         // Гість
-		mux.Get("/exhibit", handlers.Repo.GetAllExhibits)  // Гість
-		mux.Get("/exhibit/{id}", handlers.Repo.GetExhibit) // Гість
-		mux.Get("/user/{username}", handlers.Repo.GetUser) // Гість
+		mux.Post("/login", handlers.Repo.Login)                        // Гість
+		mux.Post("/sign-up", handlers.Repo.SignUp)                     // Гість
+		mux.Get("/exhibit", handlers.Repo.GetAllExhibits)              // Гість
+		mux.Get("/exhibit/{id}", handlers.Repo.GetExhibit)             // Гість
+		mux.Get("/exhibit/{id}/asset", handlers.Repo.ExhibitAsset)     // Гість
+		mux.Get("/exhibit/{id}/preview", handlers.Repo.ExhibitPreview) // Гість
+		mux.Get("/user/{username}", handlers.Repo.GetUser)             // Гість
+		// Інакше запит потрапить у /user/{username}
+		mux.With(AuthUser).Get("/user/collections", handlers.Repo.GetMyCollections)    // Зареєстровані користувачі
+		mux.With(AuthUser).Get("/user/saved-searches", handlers.Repo.GetSavedSearches) // Зареєстровані користувачі
+		mux.With(AuthUser).Get("/user/notifications", handlers.Repo.GetNotifications)  // Зареєстровані користувачі
+		mux.Get("/collections", handlers.Repo.GetPublicCollections)                    // Гість
+		mux.Get("/collections/{id}", handlers.Repo.GetCollection)                      // Гість
 
 		mux.Get("/exhibit/types", handlers.Repo.ExhibitTypes) // Гість
-		fileServer := http.FileServer(http.Dir("./storage/"))
-		mux.Handle("/storage/*", http.StripPrefix("/api/v1/storage", fileServer))
+		mux.Get("/tags", handlers.Repo.TagCloud)              // Гість
+		mux.Get("/storage/*", handlers.Repo.Storage)          // Гість
 	})
 	mux.Get("/search", handlers.Repo.Search)
 	mux.Get("/exhibit/{id}", handlers.Repo.Exhibit)
+	mux.Get("/collection/{id}", handlers.Repo.Collection)
 	return mux
 }
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
 	"fmt"
 	"github.com/alexedwards/scs/v2"
 	"github.com/joho/godotenv"
+	"github.com/seemsod1/ancy/internal/assets"
 	"github.com/seemsod1/ancy/internal/config"
 	"github.com/seemsod1/ancy/internal/handlers"
+	"github.com/seemsod1/ancy/internal/lib/signedurl"
 	"github.com/seemsod1/ancy/internal/models"
 	"github.com/seemsod1/ancy/internal/render"
+	"github.com/seemsod1/ancy/internal/search"
+	"github.com/seemsod1/ancy/internal/storage"
+	"github.com/seemsod1/ancy/internal/uploads"
+	"github.com/seemsod1/ancy/internal/workflow"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -25,6 +32,9 @@ func setup(app *config.AppConfig) error {
 
 	app.Env = env
 
+	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
 	db, err := connectDB(env)
 	if err != nil {
 		return err
@@ -32,7 +42,39 @@ func setup(app *config.AppConfig) error {
 
 	app.DB = db
 
-	if err = runSchemasMigration(db); err != nil {
+	store, err := newStorage(env)
+	if err != nil {
+		return err
+	}
+
+	app.Storage = store
+	app.Assets = assets.NewStore(db, store)
+
+	uploadManager, err := uploads.NewManager(db, env.UploadsPath, 24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	app.Uploads = uploadManager
+
+	signingKey := []byte(env.AssetSigningKey)
+	if len(signingKey) == 0 {
+		log.Println("ASSET_SIGNING_KEY is not set, using a random key; signed asset URLs won't survive a restart")
+		signingKey = make([]byte, 32)
+		if _, err = rand.Read(signingKey); err != nil {
+			return err
+		}
+	}
+	app.URLSigner = signedurl.New(signingKey, time.Hour)
+
+	wf, err := workflow.Load(env.WorkflowPath)
+	if err != nil {
+		return err
+	}
+
+	app.Workflow = wf
+
+	if err = runSchemasMigration(db, wf); err != nil {
 		return err
 	}
 
@@ -75,15 +117,67 @@ func loadEnv() (*config.EnvVariables, error) {
 	postgresPass := os.Getenv("POSTGRES_PASS")
 	postgresDBName := os.Getenv("POSTGRES_DBNAME")
 
+	storageDriver := os.Getenv("STORAGE_DRIVER")
+	if storageDriver == "" {
+		storageDriver = "local"
+	}
+	storagePath := os.Getenv("STORAGE_PATH")
+	if storagePath == "" {
+		storagePath = "storage"
+	}
+	uploadsPath := os.Getenv("UPLOADS_PATH")
+	if uploadsPath == "" {
+		uploadsPath = "uploads"
+	}
+	trashRetention := 30 * 24 * time.Hour
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		if trashRetention, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid TRASH_RETENTION: %w", err)
+		}
+	}
+	var gcInterval time.Duration
+	if v := os.Getenv("STORAGE_GC_INTERVAL"); v != "" {
+		if gcInterval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid STORAGE_GC_INTERVAL: %w", err)
+		}
+	}
+
 	return &config.EnvVariables{
 		PostgresHost:   postgresHost,
 		PostgresUser:   postgresUser,
 		PostgresPass:   postgresPass,
 		PostgresDBName: postgresDBName,
+
+		StorageDriver: storageDriver,
+		StoragePath:   storagePath,
+		UploadsPath:   uploadsPath,
+
+		StorageGCInterval: gcInterval,
+		TrashRetention:    trashRetention,
+		S3Endpoint:        os.Getenv("S3_ENDPOINT"),
+		S3Region:          os.Getenv("S3_REGION"),
+		S3Bucket:          os.Getenv("S3_BUCKET"),
+		S3AccessKey:       os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey:       os.Getenv("S3_SECRET_KEY"),
+		S3PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+
+		AssetSigningKey: os.Getenv("ASSET_SIGNING_KEY"),
+		WorkflowPath:    os.Getenv("WORKFLOW_PATH"),
 	}, nil
 }
 
-func runSchemasMigration(db *gorm.DB) error {
+func newStorage(env *config.EnvVariables) (storage.Storage, error) {
+	switch env.StorageDriver {
+	case "local":
+		return storage.NewLocal(env.StoragePath, "/api/v1/storage")
+	case "s3":
+		return storage.NewS3(env.S3Endpoint, env.S3Region, env.S3Bucket, env.S3AccessKey, env.S3SecretKey, env.S3PublicURL)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", env.StorageDriver)
+	}
+}
+
+func runSchemasMigration(db *gorm.DB, wf *workflow.Workflow) error {
 
 	if err := db.AutoMigrate(&models.ExhibitType{}); err != nil {
 		return err
@@ -100,9 +194,44 @@ func runSchemasMigration(db *gorm.DB) error {
 	if err := db.AutoMigrate(&models.User{}); err != nil {
 		return err
 	}
+	if err := db.AutoMigrate(&models.Tag{}); err != nil {
+		return err
+	}
+	// Exhibits that were already visible before announcements were added
+	// aren't announced to saved searches again
+	announced := db.Migrator().HasColumn(&models.Exhibit{}, "AnnouncedAt")
 	if err := db.AutoMigrate(&models.Exhibit{}); err != nil {
 		return err
 	}
+	if !announced {
+		if err := db.Exec("UPDATE exhibits SET announced_at = NOW() WHERE publish_at IS NULL OR publish_at <= NOW()").Error; err != nil {
+			return err
+		}
+	}
+	if err := db.AutoMigrate(&models.ExhibitRevision{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&models.ModerationDecision{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&models.ModerationClaim{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&models.Collection{}, &models.CollectionItem{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&models.Asset{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&models.Upload{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&models.SavedSearch{}, &models.Notification{}); err != nil {
+		return err
+	}
+	if err := search.Migrate(db); err != nil {
+		return err
+	}
 
 	if err := addDefaultRoles(db); err != nil {
 		return err
@@ -115,7 +244,7 @@ func runSchemasMigration(db *gorm.DB) error {
 		return err
 
 	}
-	if err := addExhibitStatuses(db); err != nil {
+	if err := addExhibitStatuses(db, wf); err != nil {
 		return err
 	}
 	return nil
@@ -166,27 +295,44 @@ func addAdminUser(db *gorm.DB) error {
 	}
 	return nil
 }
+
+// defaultExhibitTypes are created on the first start
+var defaultExhibitTypes = []models.ExhibitType{
+	{
+		Name:             "Photo",
+		AllowedMimeTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp"},
+		Preview:          models.PreviewFromAsset,
+	},
+	{
+		Name:             "Video",
+		AllowedMimeTypes: []string{"video/mp4", "video/webm", "video/ogg", "video/quicktime"},
+		Preview:          models.PreviewRequired,
+	},
+	{
+		Name:             "Audio",
+		AllowedMimeTypes: []string{"audio/mpeg", "audio/wav", "audio/ogg", "audio/flac", "audio/aac", "audio/x-m4a"},
+		Preview:          models.PreviewGenerated,
+	},
+	{
+		Name:             "Text",
+		AllowedMimeTypes: []string{"application/pdf", "text/plain"},
+		Preview:          models.PreviewRequired,
+	},
+}
+
 func addExhibitTypes(db *gorm.DB) error {
 	if count := db.Find(&models.ExhibitType{}).RowsAffected; count > 0 {
+		// Types created before they had settings get the default ones
+		for _, t := range defaultExhibitTypes {
+			if err := db.Model(&models.ExhibitType{}).Where("name = ? AND (preview IS NULL OR preview = '')", t.Name).
+				Select("AllowedMimeTypes", "Preview").Updates(&t).Error; err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 
-	types := []models.ExhibitType{
-		{
-			Name: "Photo",
-		},
-		{
-			Name: "Video",
-		},
-		{
-			Name: "Audio",
-		},
-		{
-			Name: "Text",
-		},
-	}
-
-	for _, t := range types {
+	for _, t := range defaultExhibitTypes {
 		if err := db.Create(&t).Error; err != nil {
 			return err
 		}
@@ -194,27 +340,16 @@ func addExhibitTypes(db *gorm.DB) error {
 
 	return nil
 }
-func addExhibitStatuses(db *gorm.DB) error {
-	if count := db.Find(&models.ExhibitStatus{}).RowsAffected; count > 0 {
-		return nil
-	}
-
-	statuses := []models.ExhibitStatus{
-		{
-			Name: "Pending",
-		},
-		{
-			Name: "Approved",
-		},
-		{
-			Name: "Rejected",
-		},
-	}
 
-	for _, s := range statuses {
-		if err := db.Create(&s).Error; err != nil {
+// addExhibitStatuses creates the statuses of the workflow that don't exist
+// yet and records their IDs in it
+func addExhibitStatuses(db *gorm.DB, wf *workflow.Workflow) error {
+	for _, state := range wf.States {
+		status := models.ExhibitStatus{Name: state.Name}
+		if err := db.Where(status).FirstOrCreate(&status).Error; err != nil {
 			return err
 		}
+		wf.SetID(status.Name, status.ID)
 	}
 
 	return nil
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"github.com/seemsod1/ancy/internal/trash"
+	"time"
+)
+
+// purgeTrash permanently deletes exhibits and users that have been in the
+// trash for longer than retention, checking every interval
+func purgeTrash(interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		report, err := trash.Purge(context.Background(), app.DB, app.Assets, time.Now().Add(-retention))
+		if err != nil {
+			app.ErrorLog.Println("trash purge failed:", err)
+			continue
+		}
+		if report.Exhibits > 0 || report.Users > 0 {
+			app.InfoLog.Printf("trash purge deleted %d exhibits and %d users", report.Exhibits, report.Users)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package assets
+
+import (
+	"context"
+	"errors"
+	"github.com/seemsod1/ancy/internal/models"
+	"github.com/seemsod1/ancy/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+)
+
+// Store saves files under the SHA-256 of their content, so identical uploads
+// share one stored file. Every reference from an Exhibit or User row is
+// counted in the assets table and the file is removed with the last one.
+type Store struct {
+	DB      *gorm.DB
+	Storage storage.Storage
+}
+
+func NewStore(db *gorm.DB, s storage.Storage) *Store {
+	return &Store{
+		DB:      db,
+		Storage: s,
+	}
+}
+
+// Save stores r under prefix + sha256 + "." + ext and takes a reference on
+// it. It returns the key relative to prefix, which is what the models keep
+// in their path columns.
+func (s *Store) Save(ctx context.Context, prefix string, r io.Reader, ext string) (string, error) {
+	batch := s.NewBatch(prefix)
+	defer batch.Cleanup()
+
+	name, err := batch.Stage(r, ext)
+	if err != nil {
+		return "", err
+	}
+	if err = s.DB.WithContext(ctx).Transaction(batch.Reserve); err != nil {
+		return "", err
+	}
+	if err = batch.Promote(ctx); err != nil {
+		_ = s.Release(ctx, prefix+name)
+		return "", err
+	}
+
+	return name, nil
+}
+
+// Retain takes one more reference on an already stored key in tx. Files
+// stored before assets were tracked get a row counting both the existing
+// and the new reference.
+func (s *Store) Retain(tx *gorm.DB, key string) error {
+	asset := models.Asset{Key: key, RefCount: 2}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("assets.ref_count + 1")}),
+	}).Create(&asset).Error
+}
+
+// Release drops one reference on key and deletes the file once nothing
+// points to it anymore. Files stored before assets were tracked have no row
+// and are deleted straight away.
+func (s *Store) Release(ctx context.Context, key string) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var asset models.Asset
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).Take(&asset).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.delete(ctx, key)
+		}
+		if err != nil {
+			return err
+		}
+
+		if asset.RefCount > 1 {
+			return tx.Model(&asset).Update("ref_count", asset.RefCount-1).Error
+		}
+
+		if err = tx.Delete(&asset).Error; err != nil {
+			return err
+		}
+		return s.delete(ctx, key)
+	})
+}
+
+func (s *Store) delete(ctx context.Context, key string) error {
+	if err := s.Storage.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package assets
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/seemsod1/ancy/internal/storage"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"io"
+	"strings"
+	"testing"
+)
+
+// countingStorage counts the objects written to a local storage
+type countingStorage struct {
+	*storage.Local
+	puts    int
+	failPut bool
+}
+
+func (s *countingStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	if s.failPut {
+		return errors.New("disk full")
+	}
+	s.puts++
+	return s.Local.Put(ctx, key, r)
+}
+
+// newTestStore returns a store on a mocked database and a local storage
+func newTestStore(t *testing.T) (*Store, *countingStorage, sqlmock.Sqlmock) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := storage.NewLocal(t.TempDir(), "/storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &countingStorage{Local: local}
+	return NewStore(db, s), s, mock
+}
+
+func exists(t *testing.T, s storage.Storage, key string) bool {
+	t.Helper()
+	_, err := s.Stat(context.Background(), key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestSave(t *testing.T) {
+	store, s, mock := newTestStore(t)
+	ctx := context.Background()
+
+	// The same content is stored once, every save takes a reference
+	for i := 0; i < 2; i++ {
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO "assets" .* ON CONFLICT \("key"\) DO UPDATE SET "ref_count"=assets.ref_count \+ 1`).
+			WithArgs("users/"+helloHash+".png", helloHash, int64(5), 1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		name, err := store.Save(ctx, "users/", strings.NewReader("hello"), ".png")
+		if err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		if name != helloHash+".png" {
+			t.Errorf("Save() = %q, want %q", name, helloHash+".png")
+		}
+	}
+	if !exists(t, s, "users/"+helloHash+".png") {
+		t.Error("Save() didn't store the file")
+	}
+	if s.puts != 1 {
+		t.Errorf("Save() wrote the file %d times, want once", s.puts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSaveReserveFails(t *testing.T) {
+	store, s, mock := newTestStore(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "assets"`).WillReturnError(errors.New("database is down"))
+	mock.ExpectRollback()
+
+	if _, err := store.Save(context.Background(), "users/", strings.NewReader("hello"), ".png"); err == nil {
+		t.Fatal("Save() succeeded without a reference")
+	}
+	if s.puts != 0 {
+		t.Error("Save() stored a file it has no reference for")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSavePromoteFails(t *testing.T) {
+	store, s, mock := newTestStore(t)
+	s.failPut = true
+	key := "users/" + helloHash + ".png"
+
+	// The reference taken for the file that couldn't be stored is dropped again
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "assets"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "assets" WHERE key = \$1`).WithArgs(key, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "ref_count"}).AddRow(key, 1))
+	mock.ExpectExec(`DELETE FROM "assets"`).WithArgs(key).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := store.Save(context.Background(), "users/", strings.NewReader("hello"), ".png"); err == nil {
+		t.Fatal("Save() succeeded without storing the file")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	const key = "exhibits/" + helloHash + ".png"
+	columns := []string{"key", "hash", "size", "ref_count"}
+
+	tests := []struct {
+		name string
+		// refCount is the count of the assets row, 0 when there is none
+		refCount int
+		wantFile bool
+	}{
+		{"shared", 2, true},
+		{"last reference", 1, false},
+		{"untracked", 0, false},
+	}
+	for _, tt := range tests {
+		store, s, mock := newTestStore(t)
+		ctx := context.Background()
+		if err := s.Local.Put(ctx, key, strings.NewReader("hello")); err != nil {
+			t.Fatal(err)
+		}
+
+		rows := sqlmock.NewRows(columns)
+		if tt.refCount > 0 {
+			rows.AddRow(key, helloHash, 5, tt.refCount)
+		}
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "assets" WHERE key = \$1 LIMIT \$2 FOR UPDATE`).WithArgs(key, 1).WillReturnRows(rows)
+		switch {
+		case tt.refCount > 1:
+			mock.ExpectExec(`UPDATE "assets" SET "ref_count"=\$1`).
+				WithArgs(tt.refCount-1, sqlmock.AnyArg(), key).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		case tt.refCount == 1:
+			mock.ExpectExec(`DELETE FROM "assets" WHERE "assets"."key" = \$1`).WithArgs(key).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectCommit()
+
+		if err := store.Release(ctx, key); err != nil {
+			t.Fatalf("%s: Release() error = %v", tt.name, err)
+		}
+		if got := exists(t, s, key); got != tt.wantFile {
+			t.Errorf("%s: file kept = %v, want %v", tt.name, got, tt.wantFile)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestReleaseMissingFile(t *testing.T) {
+	store, _, mock := newTestStore(t)
+
+	// Files that are already gone don't fail the release
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "assets"`).WillReturnRows(sqlmock.NewRows([]string{"key"}))
+	mock.ExpectCommit()
+
+	if err := store.Release(context.Background(), "exhibits/missing.png"); err != nil {
+		t.Errorf("Release() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,149 @@
+package assets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/seemsod1/ancy/internal/models"
+	"github.com/seemsod1/ancy/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"os"
+	"strings"
+)
+
+// Batch writes files together with the database rows referencing them.
+// Files are staged to a temporary area and hashed, their references are
+// reserved in the caller's transaction, and only after it commits are the
+// files promoted to storage:
+//
+//	batch := store.NewBatch("")
+//	defer batch.Cleanup()
+//	name, err := batch.Stage(file, ".png")
+//	err = db.Transaction(func(tx *gorm.DB) error {
+//		if err := batch.Reserve(tx); err != nil {
+//			return err
+//		}
+//		return tx.Create(&row).Error
+//	})
+//	err = batch.Promote(ctx)
+type Batch struct {
+	store  *Store
+	prefix string
+	staged map[string]*stagedFile
+	// refs lists one name per reference to reserve
+	refs []string
+}
+
+type stagedFile struct {
+	path string
+	hash string
+	size int64
+}
+
+// NewBatch starts a batch of files stored under prefix
+func (s *Store) NewBatch(prefix string) *Batch {
+	return &Batch{
+		store:  s,
+		prefix: prefix,
+		staged: make(map[string]*stagedFile),
+	}
+}
+
+// Stage spools r to a temporary file and returns its content addressed name,
+// relative to the batch prefix
+func (b *Batch) Stage(r io.Reader, ext string) (string, error) {
+	tmp, err := os.CreateTemp("", "asset-*")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	name := hash
+	if ext = strings.TrimPrefix(strings.ToLower(ext), "."); ext != "" {
+		name += "." + ext
+	}
+
+	if _, ok := b.staged[name]; ok {
+		os.Remove(tmp.Name())
+	} else {
+		b.staged[name] = &stagedFile{path: tmp.Name(), hash: hash, size: size}
+	}
+	b.refs = append(b.refs, name)
+
+	return name, nil
+}
+
+// Ref adds one more reference to an already staged name
+func (b *Batch) Ref(name string) {
+	b.refs = append(b.refs, name)
+}
+
+// Keys returns the storage key of every reference in the batch, so they
+// can be released if the rows holding them are removed again
+func (b *Batch) Keys() []string {
+	keys := make([]string, len(b.refs))
+	for i, name := range b.refs {
+		keys[i] = b.prefix + name
+	}
+	return keys
+}
+
+// Reserve takes the references of all staged files in tx. It has the
+// signature of a gorm transaction function so it can be passed directly.
+func (b *Batch) Reserve(tx *gorm.DB) error {
+	for _, name := range b.refs {
+		f := b.staged[name]
+		asset := models.Asset{Key: b.prefix + name, Hash: f.hash, Size: f.size, RefCount: 1}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("assets.ref_count + 1")}),
+		}).Create(&asset).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Promote copies staged files that aren't in storage yet. It must only be
+// called after the transaction that reserved them committed.
+func (b *Batch) Promote(ctx context.Context) error {
+	for name, f := range b.staged {
+		key := b.prefix + name
+		if _, err := b.store.Storage.Stat(ctx, key); err == nil {
+			continue
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+
+		tmp, err := os.Open(f.path)
+		if err != nil {
+			return err
+		}
+		err = b.store.Storage.Put(ctx, key, tmp)
+		tmp.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cleanup removes the temporary files of the batch
+func (b *Batch) Cleanup() {
+	for _, f := range b.staged {
+		os.Remove(f.path)
+	}
+	b.staged = make(map[string]*stagedFile)
+	b.refs = nil
+}
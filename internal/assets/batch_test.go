@@ -0,0 +1,112 @@
+package assets
+
+import (
+	"context"
+	"github.com/seemsod1/ancy/internal/storage"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// helloHash is the SHA-256 of "hello"
+const helloHash = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestStage(t *testing.T) {
+	tests := []struct {
+		content string
+		ext     string
+		want    string
+	}{
+		{"hello", ".png", helloHash + ".png"},
+		{"hello", "PNG", helloHash + ".png"},
+		{"hello", ".JPG", helloHash + ".jpg"},
+		{"hello", "", helloHash},
+		{"", ".txt", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855.txt"},
+	}
+	for _, tt := range tests {
+		batch := NewStore(nil, nil).NewBatch("")
+		got, err := batch.Stage(strings.NewReader(tt.content), tt.ext)
+		if err != nil {
+			t.Fatalf("Stage(%q, %q) error = %v", tt.content, tt.ext, err)
+		}
+		if got != tt.want {
+			t.Errorf("Stage(%q, %q) = %q, want %q", tt.content, tt.ext, got, tt.want)
+		}
+		batch.Cleanup()
+	}
+}
+
+func TestBatchDeduplicates(t *testing.T) {
+	batch := NewStore(nil, nil).NewBatch("users/")
+	defer batch.Cleanup()
+
+	first, _ := batch.Stage(strings.NewReader("hello"), ".png")
+	second, _ := batch.Stage(strings.NewReader("hello"), ".png")
+	other, _ := batch.Stage(strings.NewReader("world"), ".png")
+	batch.Ref(first)
+
+	if first != second || first == other {
+		t.Fatalf("Stage() names = %q, %q, %q", first, second, other)
+	}
+	if len(batch.staged) != 2 {
+		t.Errorf("staged %d files, want 2", len(batch.staged))
+	}
+	want := []string{"users/" + first, "users/" + first, "users/" + other, "users/" + first}
+	keys := batch.Keys()
+	if strings.Join(keys, " ") != strings.Join(want, " ") {
+		t.Errorf("Keys() = %v, want %v", keys, want)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir(), "/storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(nil, local)
+	ctx := context.Background()
+
+	batch := store.NewBatch("exhibits/")
+	name, err := batch.Stage(strings.NewReader("hello"), ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, f := range batch.staged {
+		paths = append(paths, f.path)
+	}
+
+	// A file already in storage is kept as it is
+	if _, err = batch.Stage(strings.NewReader("world"), ".txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = local.Put(ctx, "exhibits/"+batch.refs[1], strings.NewReader("kept")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = batch.Promote(ctx); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	for key, want := range map[string]string{"exhibits/" + name: "hello", "exhibits/" + batch.refs[1]: "kept"} {
+		rc, err := local.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", key, err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(got) != want {
+			t.Errorf("Get(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	batch.Cleanup()
+	for _, p := range paths {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("staged file %s left after Cleanup()", p)
+		}
+	}
+	if len(batch.Keys()) != 0 {
+		t.Errorf("Keys() after Cleanup() = %v", batch.Keys())
+	}
+}
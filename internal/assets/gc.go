@@ -0,0 +1,103 @@
+package assets
+
+import (
+	"context"
+	"errors"
+	"github.com/seemsod1/ancy/internal/models"
+	"github.com/seemsod1/ancy/internal/storage"
+	"time"
+)
+
+// DefaultProfilePhoto is shared by all users without a photo and never collected
+const DefaultProfilePhoto = "users/default.png"
+
+// GCReport lists the orphaned files found by CollectGarbage
+type GCReport struct {
+	Scanned int
+	Orphans []storage.ObjectInfo
+	Bytes   int64
+	Deleted int
+}
+
+// referencedKeys returns every storage key an Exhibit, ExhibitRevision or
+// User row points to
+func (s *Store) referencedKeys(ctx context.Context) (map[string]bool, error) {
+	keys := map[string]bool{DefaultProfilePhoto: true}
+
+	var exhibits []models.Exhibit
+	// Exhibits and users in the trash keep their files until they are purged
+	if err := s.DB.WithContext(ctx).Unscoped().Select("asset_path", "preview_path", "thumbnail_path", "card_path", "full_path",
+		"previous_asset_path", "previous_preview_path", "previous_thumbnail_path", "previous_card_path", "previous_full_path").
+		Find(&exhibits).Error; err != nil {
+		return nil, err
+	}
+	for _, e := range exhibits {
+		for _, key := range e.FileKeys() {
+			keys[key] = true
+		}
+	}
+
+	var revisions []models.ExhibitRevision
+	if err := s.DB.WithContext(ctx).Select("asset_path", "preview_path", "thumbnail_path", "card_path", "full_path").
+		Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range revisions {
+		for _, key := range r.FileKeys() {
+			keys[key] = true
+		}
+	}
+
+	var photos []string
+	if err := s.DB.WithContext(ctx).Unscoped().Model(&models.User{}).Where("profile_photo_path <> ''").
+		Pluck("profile_photo_path", &photos).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range photos {
+		keys["users/"+p] = true
+	}
+
+	return keys, nil
+}
+
+// CollectGarbage finds stored files that no Exhibit, ExhibitRevision or
+// User row references. Files modified within minAge are skipped so uploads
+// that are still being processed aren't touched. Unless dryRun is set the
+// orphans are deleted together with their asset rows.
+func (s *Store) CollectGarbage(ctx context.Context, dryRun bool, minAge time.Duration) (GCReport, error) {
+	var report GCReport
+
+	objects, err := s.Storage.List(ctx, "")
+	if err != nil {
+		return report, err
+	}
+	// Load references after listing, so a file written in between is
+	// either referenced or too new to be collected
+	referenced, err := s.referencedKeys(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	cutoff := time.Now().Add(-minAge)
+	for _, object := range objects {
+		report.Scanned++
+		if referenced[object.Key] || object.ModTime.After(cutoff) {
+			continue
+		}
+		report.Orphans = append(report.Orphans, object)
+		report.Bytes += object.Size
+
+		if dryRun {
+			continue
+		}
+		if err = s.DB.WithContext(ctx).Where("key = ?", object.Key).Delete(&models.Asset{}).Error; err != nil {
+			return report, err
+		}
+		if err = s.Storage.Delete(ctx, object.Key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return report, err
+		}
+		report.Deleted++
+	}
+
+	return report, nil
+}
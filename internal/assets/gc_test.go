@@ -0,0 +1,88 @@
+package assets
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	const (
+		orphan = "exhibits/orphan.png"
+		// fresh is an orphan too new to be collected
+		fresh = "exhibits/fresh.png"
+	)
+	referenced := []string{"exhibits/asset.png", "exhibits/previous.png", "exhibits/revision.png", "users/photo.png", DefaultProfilePhoto}
+
+	tests := []struct {
+		name       string
+		dryRun     bool
+		wantOrphan bool
+	}{
+		{"dry run", true, true},
+		{"delete", false, false},
+	}
+	for _, tt := range tests {
+		store, s, mock := newTestStore(t)
+		ctx := context.Background()
+		old := time.Now().Add(-2 * time.Hour)
+		for _, key := range append([]string{orphan, fresh}, referenced...) {
+			if err := s.Local.Put(ctx, key, strings.NewReader("data")); err != nil {
+				t.Fatal(err)
+			}
+			if key != fresh {
+				if err := os.Chtimes(filepath.Join(s.Local.Root, key), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		// Files of exhibits and users in the trash are still referenced
+		mock.ExpectQuery(`SELECT "asset_path",.*"previous_full_path" FROM "exhibits"$`).
+			WillReturnRows(sqlmock.NewRows([]string{"asset_path", "previous_asset_path"}).
+				AddRow("exhibits/asset.png", "exhibits/previous.png"))
+		mock.ExpectQuery(`SELECT "asset_path",.*"full_path" FROM "exhibit_revisions"$`).
+			WillReturnRows(sqlmock.NewRows([]string{"asset_path"}).AddRow("exhibits/revision.png"))
+		mock.ExpectQuery(`SELECT "profile_photo_path" FROM "users" WHERE profile_photo_path <> ''$`).
+			WillReturnRows(sqlmock.NewRows([]string{"profile_photo_path"}).AddRow("photo.png"))
+		if !tt.dryRun {
+			mock.ExpectBegin()
+			mock.ExpectExec(`DELETE FROM "assets" WHERE key = \$1`).WithArgs(orphan).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}
+
+		report, err := store.CollectGarbage(ctx, tt.dryRun, time.Hour)
+		if err != nil {
+			t.Fatalf("%s: CollectGarbage() error = %v", tt.name, err)
+		}
+		if report.Scanned != 2+len(referenced) {
+			t.Errorf("%s: Scanned = %d, want %d", tt.name, report.Scanned, 2+len(referenced))
+		}
+		if len(report.Orphans) != 1 || report.Orphans[0].Key != orphan || report.Bytes != 4 {
+			t.Errorf("%s: Orphans = %+v, Bytes = %d, want only %s", tt.name, report.Orphans, report.Bytes, orphan)
+		}
+		wantDeleted := 1
+		if tt.dryRun {
+			wantDeleted = 0
+		}
+		if report.Deleted != wantDeleted {
+			t.Errorf("%s: Deleted = %d, want %d", tt.name, report.Deleted, wantDeleted)
+		}
+		if got := exists(t, s, orphan); got != tt.wantOrphan {
+			t.Errorf("%s: orphan kept = %v, want %v", tt.name, got, tt.wantOrphan)
+		}
+		for _, key := range append([]string{fresh}, referenced...) {
+			if !exists(t, s, key) {
+				t.Errorf("%s: %s was deleted", tt.name, key)
+			}
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
@@ -2,9 +2,15 @@ package config
 
 import (
 	"github.com/alexedwards/scs/v2"
+	"github.com/seemsod1/ancy/internal/assets"
+	"github.com/seemsod1/ancy/internal/lib/signedurl"
+	"github.com/seemsod1/ancy/internal/storage"
+	"github.com/seemsod1/ancy/internal/uploads"
+	"github.com/seemsod1/ancy/internal/workflow"
 	"gorm.io/gorm"
 	"html/template"
 	"log"
+	"time"
 )
 
 // AppConfig holds the application config
@@ -16,6 +22,11 @@ type AppConfig struct {
 	ErrorLog      *log.Logger
 	Env           *EnvVariables
 	Session       *scs.SessionManager
+	Storage       storage.Storage
+	Assets        *assets.Store
+	Uploads       *uploads.Manager
+	URLSigner     *signedurl.Signer
+	Workflow      *workflow.Workflow
 }
 
 type EnvVariables struct {
@@ -23,4 +34,22 @@ type EnvVariables struct {
 	PostgresUser   string
 	PostgresPass   string
 	PostgresDBName string
+
+	StorageDriver string
+	StoragePath   string
+	UploadsPath   string
+	// StorageGCInterval enables periodic deletion of orphaned files when set
+	StorageGCInterval time.Duration
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKey       string
+	S3SecretKey       string
+	S3PublicURL       string
+
+	AssetSigningKey string
+	// TrashRetention is how long deleted exhibits and users can be restored
+	TrashRetention time.Duration
+	// WorkflowPath points to a JSON exhibit workflow, the default one is used when empty
+	WorkflowPath string
 }
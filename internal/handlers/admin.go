@@ -3,61 +3,14 @@ package handlers
 import (
 	"github.com/go-chi/chi/v5"
 	rend "github.com/go-chi/render"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
 	"github.com/seemsod1/ancy/internal/lib/api/response"
 	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
 	"net/http"
+	"time"
 )
 
-func (m *Repository) ApproveExhibit(w http.ResponseWriter, r *http.Request) {
-	exhibitID := chi.URLParam(r, "id")
-	if exhibitID == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		rend.JSON(w, r, response.Error("exhibit ID is required"))
-		return
-	}
-
-	var statusID int
-	if err := m.App.DB.Table("exhibit_statuses").Where("name = ?", "Approved").Pluck("id", &statusID).Error; err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to get status"))
-		return
-	}
-
-	if err := m.App.DB.Model(&models.Exhibit{}).Where("id = ?", exhibitID).Update("status_id", statusID).Error; err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to approve exhibit"))
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	rend.JSON(w, r, response.OK())
-}
-
-func (m *Repository) RejectExhibit(w http.ResponseWriter, r *http.Request) {
-	exhibitID := chi.URLParam(r, "id")
-	if exhibitID == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		rend.JSON(w, r, response.Error("exhibit ID is required"))
-		return
-	}
-
-	var statusID int
-	if err := m.App.DB.Table("exhibit_statuses").Where("name = ?", "Rejected").Pluck("id", &statusID).Error; err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to get status"))
-		return
-	}
-
-	if err := m.App.DB.Model(&models.Exhibit{}).Where("id = ?", exhibitID).Update("status_id", statusID).Error; err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to reject exhibit"))
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	rend.JSON(w, r, response.OK())
-}
-
 func (m Repository) MakeAdmin(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "id")
 	if userID == "" {
@@ -130,16 +83,24 @@ func (m Repository) DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 	}
 
-	withExhibits := r.URL.Query().Get("withExhibits")
-	if withExhibits == "true" {
-		if err := m.App.DB.Where("author_id = ?", userID).Delete(&models.Exhibit{}).Error; err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			rend.JSON(w, r, response.Error("failed to delete user exhibits"))
-			return
-		}
+	var user models.User
+	if err := m.App.DB.Where("id = ?", userID).Take(&user).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.NotFound("user not found"))
+		return
 	}
 
-	if err := m.App.DB.Where("id = ?", userID).Delete(&models.User{}).Error; err != nil {
+	// The user and their exhibits go to the trash with the same deletion
+	// time, so RestoreUser can bring them back together. Exhibits can't
+	// outlive their author, purging the user would leave them without one.
+	now := time.Now()
+	err := m.App.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Exhibit{}).Where("author_id = ?", user.ID).Update("deleted_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&user).Update("deleted_at", now).Error
+	})
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		rend.JSON(w, r, response.Error("failed to delete user"))
 		return
@@ -150,18 +111,38 @@ func (m Repository) DeleteUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m Repository) GetAllUsers(w http.ResponseWriter, r *http.Request) {
+	p, ok := pagingRequest(w, r, userOrders, "newest")
+	if !ok {
+		return
+	}
+
+	query := m.App.DB.Model(&models.User{})
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count users"))
+		return
+	}
+
 	var users []models.User
-	if err := m.App.DB.Preload("Role").Find(&users).Error; err != nil {
+	if err = p.Apply(query).Preload("Role").Find(&users).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get users"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "users", users, func(u models.User) int { return u.ID })
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		rend.JSON(w, r, response.Error("failed to get users"))
 		return
 	}
+	page.Total = total
 
-	for i := range users {
-		users[i].Password = ""
+	for i := range page.Items {
+		page.Items[i].Password = ""
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	rend.JSON(w, r, users)
+	rend.JSON(w, r, page)
 }
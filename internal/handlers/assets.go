@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	rend "github.com/go-chi/render"
+	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/lib/signedurl"
+	"github.com/seemsod1/ancy/internal/models"
+	"github.com/seemsod1/ancy/internal/storage"
+	"gorm.io/gorm"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// canViewExhibit applies the visibility rules of GetExhibit: exhibits that
+// aren't in a public status are only visible to admins and their author
+func (m *Repository) canViewExhibit(ctx context.Context, exhibit models.Exhibit) bool {
+	if m.isPublic(exhibit) {
+		return true
+	}
+	return m.isAuthorOrAdmin(ctx, exhibit)
+}
+
+// isAuthorOrAdmin reports whether the logged in user wrote exhibit or is an admin
+func (m *Repository) isAuthorOrAdmin(ctx context.Context, exhibit models.Exhibit) bool {
+	if m.isAdmin(ctx) {
+		return true
+	}
+	id := m.GetLoggedInUserID(ctx)
+	return id != 0 && exhibit.AuthorID == id
+}
+
+// signExhibitURLs fills in signed URLs for the asset and preview of exhibit.
+// Callers must have checked that the user may see the exhibit.
+func (m *Repository) signExhibitURLs(exhibit *models.Exhibit) {
+	now := time.Now()
+	exhibit.AssetURL = m.App.URLSigner.Sign(fmt.Sprintf("/api/v1/exhibit/%d/asset", exhibit.ID), now)
+	exhibit.PreviewURL = m.App.URLSigner.Sign(fmt.Sprintf("/api/v1/exhibit/%d/preview", exhibit.ID), now)
+}
+
+// hasValidSignature checks the signature of the request URL and writes an
+// error response when it is missing, invalid or expired
+func (m *Repository) hasValidSignature(w http.ResponseWriter, r *http.Request) bool {
+	err := m.App.URLSigner.Verify(r.URL.Path, r.URL.Query(), time.Now())
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, signedurl.ErrExpired):
+		w.WriteHeader(http.StatusForbidden)
+		rend.JSON(w, r, response.Error("url expired"))
+	default:
+		w.WriteHeader(http.StatusUnauthorized)
+		rend.JSON(w, r, response.Error("unauthorized"))
+	}
+	return false
+}
+
+// ExhibitAsset streams the asset of an exhibit
+func (m *Repository) ExhibitAsset(w http.ResponseWriter, r *http.Request) {
+	exhibit, ok := m.getServableExhibit(w, r)
+	if !ok {
+		return
+	}
+	m.serveAsset(w, r, exhibit.AssetPath, exhibit.MimeType, m.isPublic(exhibit))
+}
+
+// ExhibitPreview streams the preview of an exhibit, in the rendition given by size
+func (m *Repository) ExhibitPreview(w http.ResponseWriter, r *http.Request) {
+	exhibit, ok := m.getServableExhibit(w, r)
+	if !ok {
+		return
+	}
+	key := exhibit.PreviewPath
+	if p := renditionPath(exhibit, r.URL.Query().Get("size")); p != "" {
+		key = p
+	}
+	m.serveAsset(w, r, key, "", m.isPublic(exhibit))
+}
+
+// Storage streams a stored file by key. Exhibit files are only served when
+// one of the exhibits referencing them is public.
+func (m *Repository) Storage(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+
+	// Profile photos are public
+	if strings.HasPrefix(key, "users/") {
+		m.serveAsset(w, r, key, "", true)
+		return
+	}
+
+	var exhibits []models.Exhibit
+	if err := m.App.DB.Preload("Status").
+		Where("asset_path = ? OR preview_path = ? OR thumbnail_path = ? OR card_path = ? OR full_path = ?", key, key, key, key, key).
+		Find(&exhibits).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get file"))
+		return
+	}
+
+	if len(exhibits) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.NotFound("file not found"))
+		return
+	}
+
+	// Files of exhibits that aren't public are only served through the
+	// signed URLs of ExhibitAsset and ExhibitPreview
+	for _, exhibit := range exhibits {
+		if !m.isPublic(exhibit) {
+			continue
+		}
+		contentType := ""
+		if key == exhibit.AssetPath {
+			contentType = exhibit.MimeType
+		}
+		m.serveAsset(w, r, key, contentType, true)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	rend.JSON(w, r, response.NotFound("file not found"))
+}
+
+// getServableExhibit loads the exhibit from the URL. Files of exhibits that
+// aren't approved are only served for signed URLs.
+func (m *Repository) getServableExhibit(w http.ResponseWriter, r *http.Request) (models.Exhibit, bool) {
+	var exhibit models.Exhibit
+
+	var eId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &eId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid exhibit ID"))
+		return exhibit, false
+	}
+	if err := m.App.DB.Preload("Status").Where("id = ?", eId).Take(&exhibit).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.Error("exhibit not found"))
+		return exhibit, false
+	}
+	if !m.isPublic(exhibit) && !m.hasValidSignature(w, r) {
+		return exhibit, false
+	}
+	return exhibit, true
+}
+
+// isPublic reports whether guests can see the exhibit, which decides if its
+// files may be kept in shared caches
+func (m *Repository) isPublic(exhibit models.Exhibit) bool {
+	now := time.Now()
+	if exhibit.PublishAt != nil && exhibit.PublishAt.After(now) {
+		return false
+	}
+	if exhibit.UnpublishAt != nil && !exhibit.UnpublishAt.After(now) {
+		return false
+	}
+	return m.hasPublicStatus(exhibit)
+}
+
+// hasPublicStatus reports whether the exhibit is in a public status,
+// regardless of its publishing window
+func (m *Repository) hasPublicStatus(exhibit models.Exhibit) bool {
+	state, _ := m.App.Workflow.StateByID(exhibit.StatusID)
+	return state.Public
+}
+
+// visibleTo limits a query on exhibits to the ones the logged in user may
+// see, the same rules as canViewExhibit
+func (m *Repository) visibleTo(ctx context.Context) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if m.isAdmin(ctx) {
+			return db
+		}
+		visible := m.publiclyVisible(db.Session(&gorm.Session{NewDB: true}))
+		return db.Where(visible.Or("exhibits.author_id = ?", m.GetLoggedInUserID(ctx)))
+	}
+}
+
+// publiclyVisible limits a query on exhibits to the ones guests can see
+func (m *Repository) publiclyVisible(db *gorm.DB) *gorm.DB {
+	now := time.Now()
+	return db.Where("exhibits.status_id IN ?", m.App.Workflow.PublicIDs()).
+		Where("exhibits.publish_at IS NULL OR exhibits.publish_at <= ?", now).
+		Where("exhibits.unpublish_at IS NULL OR exhibits.unpublish_at > ?", now)
+}
+
+// serveAsset streams key with support for Range, If-None-Match and
+// If-Modified-Since requests
+func (m *Repository) serveAsset(w http.ResponseWriter, r *http.Request, key, contentType string, public bool) {
+	info, err := m.App.Storage.Stat(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+			w.WriteHeader(http.StatusNotFound)
+			rend.JSON(w, r, response.NotFound("file not found"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get file"))
+		return
+	}
+
+	file, err := storage.Open(r.Context(), m.App.Storage, key, info.Size)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get file"))
+		return
+	}
+	defer file.Close()
+
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if info.ETag != "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+	if public {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	http.ServeContent(w, r, path.Base(key), info.ModTime, file)
+}
@@ -1,17 +1,26 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/gabriel-vasile/mimetype"
 	"github.com/go-chi/chi/v5"
 	rend "github.com/go-chi/render"
 	"github.com/seemsod1/ancy/internal/helpers"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
 	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/media"
 	"github.com/seemsod1/ancy/internal/models"
+	"github.com/seemsod1/ancy/internal/workflow"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"image/png"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 )
 
 func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
@@ -32,15 +41,6 @@ func (m *Repository) CreateExhibit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	title := r.Form.Get("title")
-	typeID, err := strconv.Atoi(r.Form.Get("type"))
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		rend.JSON(w, r, response.Error("invalid type"))
-		return
-
-	}
-	description := r.Form.Get("description")
 	file, fileHeader, err := r.FormFile("file") // Отримуємо файл з форми
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -49,86 +49,121 @@ func (m *Repository) CreateExhibit(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	if title == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		rend.JSON(w, r, response.Error("title is required"))
-		return
-	}
-	finalTitle, err := helpers.GenerateHashedFileName(title)
+	m.createExhibit(w, r, file, fileHeader.Filename)
+}
+
+// createExhibit creates a pending exhibit from the form fields of r and the
+// asset in file. It writes the response and reports whether the exhibit was created.
+func (m *Repository) createExhibit(w http.ResponseWriter, r *http.Request, file io.ReadSeeker, fileName string) bool {
+	title := r.Form.Get("title")
+	typeID, err := strconv.Atoi(r.Form.Get("type"))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to generate file name"))
-		return
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid type"))
+		return false
 
 	}
+	description := r.Form.Get("description")
 
-	fileFormat := helpers.GetFileFormat(fileHeader.Filename)
-	filePath := finalTitle + "." + fileFormat
-	newFile, err := os.Create("storage/" + filePath)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to save file"))
-		return
+	if title == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("title is required"))
+		return false
 	}
-	defer newFile.Close()
 
-	_, err = io.Copy(newFile, file)
+	tagNames, err := parseTags(r.Form["tags"])
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to save file"))
-		return
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error(err.Error()))
+		return false
 	}
 
 	var ExhibitType models.ExhibitType
 	if err = m.App.DB.First(&ExhibitType, typeID).Error; err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		rend.JSON(w, r, response.Error("type not found"))
-		return
+		return false
 	}
-	var previewPhotoPath string
-	if ExhibitType.Name != "Photo" {
+
+	fileType, ok := checkAsset(w, r, file, ExhibitType)
+	if !ok {
+		return false
+	}
+	metadata, err := media.ExtractMetadata(file, mimeType(fileType))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to read file"))
+		return false
+	}
+
+	// previewPhoto stays nil when the asset is its own preview
+	var previewPhoto io.ReadSeeker
+	var previewExt string
+	if ExhibitType.Preview != models.PreviewFromAsset {
 
 		//process preview photo
-		previewPhoto, previewPhotoHeader, err := r.FormFile("preview_photo")
-		if err != nil {
+		uploadedPreview, _, err := r.FormFile("preview_photo")
+		// Finalized uploads may be posted as a plain form, which has no preview
+		if errors.Is(err, http.ErrNotMultipart) {
+			err = http.ErrMissingFile
+		}
+		switch {
+		case err == nil:
+			defer uploadedPreview.Close()
+
+			previewType, err := media.Check("preview_photo", uploadedPreview, media.PreviewTypes)
+			if err != nil {
+				uploadError(w, r, err)
+				return false
+			}
+			previewPhoto, previewExt = uploadedPreview, previewType.Extension()
+		case !errors.Is(err, http.ErrMissingFile):
 			w.WriteHeader(http.StatusBadRequest)
 			rend.JSON(w, r, response.Error("failed to get preview photo"))
-			return
+			return false
+		case ExhibitType.Preview == models.PreviewGenerated:
+			preview, ext, err := generatePreview(file, mimeType(fileType))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				rend.JSON(w, r, response.Error("failed to generate preview"))
+				return false
+			}
+			if preview != file {
+				previewPhoto, previewExt = preview, ext
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error("preview photo is required"))
+			return false
 		}
-		defer previewPhoto.Close()
+	}
 
-		previewPhotoPath = finalTitle + "_preview." + helpers.GetFileFormat(previewPhotoHeader.Filename)
-		newPreviewPhoto, err := os.Create("storage/" + previewPhotoPath)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			rend.JSON(w, r, response.Error("failed to save preview photo"))
-			return
-		}
-		defer newPreviewPhoto.Close()
+	authorID, _ := m.App.Session.Get(r.Context(), "user_id").(int)
+	initial, _ := m.App.Workflow.State(m.App.Workflow.Initial)
+
+	// Files are staged first and only promoted to storage once the exhibit
+	// row is committed, so a failure at any step leaves nothing behind
+	batch := m.App.Assets.NewBatch("")
+	defer batch.Cleanup()
 
-		_, err = io.Copy(newPreviewPhoto, previewPhoto)
+	filePath, err := batch.Stage(file, fileExtension(fileType, fileName))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to save file"))
+		return false
+	}
+
+	var previewPhotoPath string
+	if previewPhoto != nil {
+		previewPhotoPath, err = batch.Stage(previewPhoto, previewExt)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			rend.JSON(w, r, response.Error("failed to save preview photo"))
-			return
+			return false
 		}
 	} else {
 		previewPhotoPath = filePath
-	}
-
-	authorID, _ := m.App.Session.Get(r.Context(), "user_id").(int)
-	var statusID int
-	if err = m.App.DB.Table("exhibit_statuses").Where("name = ?", "Pending").Pluck("id", &statusID).Error; err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to get status"))
-		return
-	}
-
-	var tId int
-	if err = m.App.DB.Table("exhibit_types").Where("id = ?", typeID).Pluck("id", &tId).Error; err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to get type"))
-		return
+		batch.Ref(filePath)
 	}
 
 	exhibit := models.Exhibit{
@@ -137,31 +172,97 @@ func (m *Repository) CreateExhibit(w http.ResponseWriter, r *http.Request) {
 		Description: description,
 		AssetPath:   filePath,
 		PreviewPath: previewPhotoPath,
+		MimeType:    mimeType(fileType),
+		Metadata:    metadata,
 		AuthorID:    authorID,
-		StatusID:    statusID,
+		StatusID:    initial.ID,
 	}
 
-	if err = m.App.DB.Create(&exhibit).Error; err != nil {
+	var previewSource io.ReadSeeker = file
+	if previewPhoto != nil {
+		previewSource = previewPhoto
+	}
+	if err = m.stageRenditions(batch, &exhibit, previewSource); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to save preview photo"))
+		return false
+	}
+
+	err = m.App.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := batch.Reserve(tx); err != nil {
+			return err
+		}
+		tags, err := findOrCreateTags(tx, tagNames)
+		if err != nil {
+			return err
+		}
+		exhibit.Tags = tags
+		return tx.Create(&exhibit).Error
+	})
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		rend.JSON(w, r, response.Error("failed to create exhibit"))
-		return
+		return false
+	}
+
+	if err = batch.Promote(r.Context()); err != nil {
+		// Undo the committed row, releasing drops whatever was promoted
+		if err = m.App.DB.Unscoped().Delete(&exhibit).Error; err == nil {
+			err = m.releaseExhibitFiles(r.Context(), exhibit)
+		}
+		if err != nil {
+			m.App.ErrorLog.Println("failed to roll back exhibit:", err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to save file"))
+		return false
 	}
 
 	w.WriteHeader(http.StatusCreated)
 	rend.JSON(w, r, response.OK())
-
+	return true
 }
 
 func (m *Repository) GetMyExhibits(w http.ResponseWriter, r *http.Request) {
 	authorID, _ := m.App.Session.Get(r.Context(), "user_id").(int)
+	p, ok := pagingRequest(w, r, exhibitOrders(""), "newest")
+	if !ok {
+		return
+	}
+
+	query := m.App.DB.Model(&models.Exhibit{}).Where("author_id = ?", authorID)
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count exhibits"))
+		return
+	}
+
 	var exhibits []models.Exhibit
-	if err := m.App.DB.Where("author_id = ?", authorID).Preload("Type").Preload("Status").Find(&exhibits).Error; err != nil {
+	if err = p.Apply(query).Preload("Type").Preload("Status").Preload("Tags").Find(&exhibits).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get exhibits"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "exhibits", exhibits, func(e models.Exhibit) int { return e.ID })
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		rend.JSON(w, r, response.Error("failed to get exhibits"))
 		return
 	}
+	page.Total = total
+	exhibits = page.Items
+
+	for i := range exhibits {
+		m.signExhibitURLs(&exhibits[i])
+	}
+	if err := m.attachLatestDecisions(r.Context(), exhibits); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get moderation decisions"))
+		return
+	}
 
-	jsonData, err := json.Marshal(exhibits)
+	jsonData, err := json.Marshal(page)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		rend.JSON(w, r, response.Error("failed to marshal exhibits to JSON"))
@@ -187,34 +288,307 @@ func (m *Repository) DeleteExhibit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authorID, _ := m.App.Session.Get(r.Context(), "user_id").(int)
 	var exhibit models.Exhibit
 	if err := m.App.DB.Where("id = ?", eId).First(&exhibit).Error; err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		rend.JSON(w, r, response.Error("exhibit not found"))
 		return
 	}
-	userRole := m.GetLoggedInUserRole(r.Context())
-
-	if userRole != "Admin" && exhibit.AuthorID != authorID {
+	if !m.isAuthorOrAdmin(r.Context(), exhibit) {
 		w.WriteHeader(http.StatusForbidden)
 		rend.JSON(w, r, response.Error("forbidden"))
 		return
 	}
 
-	if err := os.Remove("storage/" + exhibit.AssetPath); err != nil {
+	// The exhibit goes to the trash, its files are released when it is purged
+	if err := m.App.DB.Delete(&exhibit).Error; err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to delete exhibit file"))
+		rend.JSON(w, r, response.Error("failed to delete exhibit"))
 		return
 	}
 
-	if err := m.App.DB.Delete(&exhibit).Error; err != nil {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateExhibit changes the metadata of an exhibit and optionally replaces
+// its file or preview. Changes go through the edit transition of the
+// workflow, which sends approved and rejected exhibits back to moderation by
+// default. Files of public exhibits are kept until the change is approved.
+func (m *Repository) UpdateExhibit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("file too big"))
+		return
+	}
+
+	var eId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &eId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid exhibit ID"))
+		return
+	}
+
+	var exhibit models.Exhibit
+	if err := m.App.DB.Preload("Type").Preload("Status").Preload("Tags").Where("id = ?", eId).Take(&exhibit).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.Error("exhibit not found"))
+		return
+	}
+	if exhibit.AuthorID != m.GetLoggedInUserID(r.Context()) {
+		w.WriteHeader(http.StatusForbidden)
+		rend.JSON(w, r, response.Error("forbidden"))
+		return
+	}
+
+	updated := exhibit
+	var tagNames []string
+	_, hasTags := r.PostForm["tags"]
+	if hasTags {
+		var err error
+		if tagNames, err = parseTags(r.PostForm["tags"]); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+		hasTags = !sameTags(exhibit.Tags, tagNames)
+	}
+	if title, ok := formValue(r, "title"); ok {
+		if title == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error("title is required"))
+			return
+		}
+		updated.Title = title
+	}
+	if description, ok := formValue(r, "description"); ok {
+		updated.Description = description
+	}
+	if typeValue, ok := formValue(r, "type"); ok {
+		typeID, err := strconv.Atoi(typeValue)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error("invalid type"))
+			return
+		}
+		if err = m.App.DB.First(&updated.Type, typeID).Error; err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			rend.JSON(w, r, response.Error("type not found"))
+			return
+		}
+		updated.TypeID = updated.Type.ID
+	}
+
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil && !errors.Is(err, http.ErrMissingFile) {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("failed to get file"))
+		return
+	}
+	hasFile := err == nil
+	if hasFile {
+		defer file.Close()
+	}
+
+	previewPhoto, _, err := r.FormFile("preview_photo")
+	if err != nil && !errors.Is(err, http.ErrMissingFile) {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("failed to get preview photo"))
+		return
+	}
+	hasPreview := err == nil
+	if hasPreview {
+		defer previewPhoto.Close()
+	}
+
+	batch := m.App.Assets.NewBatch("")
+	defer batch.Cleanup()
+
+	if hasFile {
+		fileType, ok := checkAsset(w, r, file, updated.Type)
+		if !ok {
+			return
+		}
+		if updated.AssetPath, err = batch.Stage(file, fileExtension(fileType, fileHeader.Filename)); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			rend.JSON(w, r, response.Error("failed to save file"))
+			return
+		}
+		updated.MimeType = mimeType(fileType)
+		if updated.Metadata, err = media.ExtractMetadata(file, updated.MimeType); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			rend.JSON(w, r, response.Error("failed to read file"))
+			return
+		}
+	} else if allowed := updated.Type.AllowedTypes(); !media.IsAllowed(exhibit.MimeType, allowed) {
+		uploadError(w, r, &media.UnsupportedTypeError{Field: "file", Detected: exhibit.MimeType, Allowed: allowed})
+		return
+	}
+
+	// The preview and its renditions are replaced together
+	var previewSource io.ReadSeeker
+	var previewExt string
+	ownPreview := exhibit.PreviewPath == exhibit.AssetPath
+	switch {
+	case updated.Type.Preview == models.PreviewFromAsset && hasFile:
+		previewSource = file
+	case updated.Type.Preview == models.PreviewFromAsset:
+		if !ownPreview {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error("file is required to change the type"))
+			return
+		}
+	case hasPreview:
+		previewType, err := media.Check("preview_photo", previewPhoto, media.PreviewTypes)
+		if err != nil {
+			uploadError(w, r, err)
+			return
+		}
+		previewSource, previewExt = previewPhoto, previewType.Extension()
+	case ownPreview && updated.Type.Preview == models.PreviewGenerated && hasFile:
+		if previewSource, previewExt, err = generatePreview(file, updated.MimeType); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error("failed to generate preview"))
+			return
+		}
+	case ownPreview && updated.Type.Preview != models.PreviewGenerated:
+		// The asset was its own preview, the type needs a separate one
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("failed to get preview photo"))
+		return
+	}
+
+	if previewSource != nil {
+		if previewSource == file {
+			updated.PreviewPath = updated.AssetPath
+			batch.Ref(updated.AssetPath)
+		} else if updated.PreviewPath, err = batch.Stage(previewSource, previewExt); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			rend.JSON(w, r, response.Error("failed to save preview photo"))
+			return
+		}
+		updated.ThumbnailPath, updated.CardPath, updated.FullPath = "", "", ""
+		if err = m.stageRenditions(batch, &updated, previewSource); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			rend.JSON(w, r, response.Error("failed to save preview photo"))
+			return
+		}
+	}
+
+	// Replaced files of an approved exhibit are kept until the change is
+	// approved, other replaced files are released once the change is saved
+	keepPrevious := m.hasPublicStatus(exhibit) || hasPreviousFiles(exhibit)
+	var released []string
+	previous := fileColumns(&exhibit)
+	for i, col := range fileColumns(&updated) {
+		// The first column is the asset, the rest belong to the preview
+		if i == 0 && !hasFile || i > 0 && previewSource == nil {
+			continue
+		}
+		old := *previous[i][0]
+		if old == "" {
+			continue
+		}
+		if keepPrevious && *col[1] == "" {
+			*col[1] = old
+			if i == 0 {
+				updated.PreviousMimeType = exhibit.MimeType
+				updated.PreviousMetadata = exhibit.Metadata
+			}
+		} else {
+			released = append(released, old)
+		}
+	}
+
+	changed := hasFile || previewSource != nil || hasTags || updated.Title != exhibit.Title ||
+		updated.Description != exhibit.Description || updated.TypeID != exhibit.TypeID
+	if !changed {
+		w.WriteHeader(http.StatusOK)
+		rend.JSON(w, r, response.OK())
+		return
+	}
+
+	var revision models.ExhibitRevision
+	var decision models.ModerationDecision
+	err = m.App.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := batch.Reserve(tx); err != nil {
+			return err
+		}
+		var err error
+		if revision, err = m.recordRevision(tx, exhibit, exhibit.AuthorID); err != nil {
+			return err
+		}
+
+		var transitionReleased []string
+		decision, transitionReleased, err = m.applyTransition(r.Context(), tx, &updated, exhibit.AuthorID, "edit", "")
+		switch {
+		case errors.Is(err, workflow.ErrUnknownTransition), errors.Is(err, workflow.ErrNotAllowed):
+			// Statuses without an edit transition are kept
+		case err != nil:
+			return err
+		default:
+			released = append(released, transitionReleased...)
+		}
+
+		if hasTags {
+			tags, err := findOrCreateTags(tx, tagNames)
+			if err != nil {
+				return err
+			}
+			if err = tx.Model(&updated).Association("Tags").Replace(tags); err != nil {
+				return err
+			}
+		}
+		return tx.Omit(clause.Associations).Save(&updated).Error
+	})
+	if err != nil {
+		status, msg := transitionError(err, "failed to update exhibit")
+		w.WriteHeader(status)
+		rend.JSON(w, r, response.Error(msg))
+		return
+	}
+
+	if err = batch.Promote(r.Context()); err != nil {
+		// Put the exhibit back as it was, drop the revision of the edit that
+		// didn't happen and the new references
+		err = m.App.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Omit(clause.Associations).Save(&exhibit).Error; err != nil {
+				return err
+			}
+			if hasTags {
+				if err := tx.Model(&exhibit).Association("Tags").Replace(exhibit.Tags); err != nil {
+					return err
+				}
+			}
+			if decision.ID != 0 {
+				if err := tx.Delete(&decision).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&revision).Error
+		})
+		if err == nil {
+			err = m.releaseFiles(r.Context(), append(batch.Keys(), revision.FileKeys()...))
+		}
+		if err != nil {
+			m.App.ErrorLog.Println("failed to roll back exhibit update:", err)
+		}
 		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to delete exhibit"))
+		rend.JSON(w, r, response.Error("failed to save file"))
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	if err = m.releaseFiles(r.Context(), released); err != nil {
+		// The garbage collector picks up whatever is left over
+		m.App.ErrorLog.Println("failed to release replaced files:", err)
+	}
+	if decision.ID != 0 {
+		if err = m.announceExhibit(r.Context(), exhibit.ID); err != nil {
+			m.App.ErrorLog.Println("failed to notify saved searches:", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, response.OK())
 }
 
 func (m *Repository) UpdatePhoto(w http.ResponseWriter, r *http.Request) {
@@ -239,45 +613,128 @@ func (m *Repository) UpdatePhoto(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	finalTitle, err := helpers.GenerateHashedFileName(user.Username)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to generate file name"))
-		return
-
-	}
-
 	fileFormat := helpers.GetFileFormat(fileHeader.Filename)
-	filePath := finalTitle + "." + fileFormat
-	newFile, err := os.Create("storage/users/" + filePath)
+	filePath, err := m.App.Assets.Save(r.Context(), "users/", file, fileFormat)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		rend.JSON(w, r, response.Error("failed to save file"))
 		return
 	}
-	defer newFile.Close()
 
-	_, err = io.Copy(newFile, file)
-	if err != nil {
+	oldPhotoPath := user.ProfilePhotoPath
+	user.ProfilePhotoPath = filePath
+	if err = m.App.DB.Save(&user).Error; err != nil {
+		_ = m.App.Assets.Release(r.Context(), "users/"+filePath)
 		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to save file"))
+		rend.JSON(w, r, response.Error("failed to update user"))
 		return
 	}
-	// Delete old photo
-	if user.ProfilePhotoPath != "default.png" {
-		if err = os.Remove("storage/users/" + user.ProfilePhotoPath); err != nil {
+
+	// Release old photo
+	if oldPhotoPath != "default.png" {
+		if err = m.App.Assets.Release(r.Context(), "users/"+oldPhotoPath); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			rend.JSON(w, r, response.Error("failed to delete old photo"))
 			return
 		}
 	}
 
-	user.ProfilePhotoPath = filePath
-	if err = m.App.DB.Save(&user).Error; err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		rend.JSON(w, r, response.Error("failed to update user"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadError writes the response for an upload rejected by media.Check
+func uploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var typeErr *media.UnsupportedTypeError
+	if errors.As(err, &typeErr) {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		rend.JSON(w, r, response.ErrorWithDetails("unsupported file type", typeErr))
 		return
 	}
+	w.WriteHeader(http.StatusBadRequest)
+	rend.JSON(w, r, response.Error("failed to read file"))
+}
 
-	w.WriteHeader(http.StatusNoContent)
+// checkAsset makes sure file follows the upload rules of exhibitType and
+// returns its sniffed type. It writes the response when it doesn't.
+func checkAsset(w http.ResponseWriter, r *http.Request, file io.ReadSeeker, exhibitType models.ExhibitType) (*mimetype.MIME, bool) {
+	if exhibitType.MaxSize > 0 {
+		size, err := file.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			uploadError(w, r, err)
+			return nil, false
+		}
+		if size > exhibitType.MaxSize {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			rend.JSON(w, r, response.Error(fmt.Sprintf("file is larger than %d bytes", exhibitType.MaxSize)))
+			return nil, false
+		}
+	}
+
+	fileType, err := media.Check("file", file, exhibitType.AllowedTypes())
+	if err != nil {
+		uploadError(w, r, err)
+		return nil, false
+	}
+	return fileType, true
+}
+
+var errNoPreview = errors.New("no preview can be generated for this file")
+
+// generatePreview makes the preview of an asset uploaded without a preview
+// photo. Images are their own preview and supported audio gets a waveform.
+func generatePreview(file io.ReadSeeker, mimeType string) (io.ReadSeeker, string, error) {
+	switch {
+	case media.IsAllowed(mimeType, media.PreviewTypes):
+		return file, "", nil
+	case media.CanRenderWaveform(mimeType):
+		preview, err := renderWaveform(file, mimeType)
+		return preview, ".png", err
+	}
+	return nil, "", errNoPreview
+}
+
+// renderWaveform draws the waveform of the audio in file as a PNG and rewinds file
+func renderWaveform(file io.ReadSeeker, mimeType string) (io.ReadSeeker, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, err := media.Waveform(file, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf.Bytes()), nil
+}
+
+// formValue returns the value of a posted form field and whether it was sent at all
+func formValue(r *http.Request, name string) (string, bool) {
+	values, ok := r.PostForm[name]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// fileExtension prefers the extension of the sniffed type over the client supplied file name
+func fileExtension(mtype *mimetype.MIME, fileName string) string {
+	if ext := mtype.Extension(); ext != "" {
+		return ext
+	}
+	return helpers.GetFileFormat(fileName)
+}
+
+// mimeType returns the sniffed type without parameters such as charset
+func mimeType(mtype *mimetype.MIME) string {
+	t, _, _ := strings.Cut(mtype.String(), ";")
+	return t
 }
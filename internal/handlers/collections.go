@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	rend "github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
+	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+)
+
+// CollectionForm creates or updates a collection. ExhibitIDs lists the
+// exhibits in order, on update they are only replaced when the field is sent.
+type CollectionForm struct {
+	Title          string `json:"title" validate:"required,max=255"`
+	Description    string `json:"description" validate:"max=1024"`
+	Public         bool   `json:"public"`
+	CoverExhibitID *int   `json:"cover_exhibit_id"`
+	ExhibitIDs     []int  `json:"exhibit_ids" validate:"max=500"`
+}
+
+// CollectionExhibitsForm replaces the exhibits of a collection
+type CollectionExhibitsForm struct {
+	ExhibitIDs []int `json:"exhibit_ids" validate:"max=500"`
+}
+
+var (
+	errInvalidExhibits = errors.New("exhibits don't exist or can't be added")
+	errInvalidCover    = errors.New("cover must be one of the exhibits of the collection")
+)
+
+// GetMyCollections lists the collections of the logged in user
+func (m *Repository) GetMyCollections(w http.ResponseWriter, r *http.Request) {
+	m.listCollections(w, r, m.App.DB.Model(&models.Collection{}).Where("owner_id = ?", m.GetLoggedInUserID(r.Context())),
+		func(db *gorm.DB) *gorm.DB { return db.Preload("CoverExhibit") })
+}
+
+// CreateCollection creates a collection owned by the logged in user
+func (m *Repository) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	var req CollectionForm
+	if err := rend.DecodeJSON(r.Body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+	if err := validator.New().Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+
+	collection := models.Collection{
+		Title:          req.Title,
+		Description:    req.Description,
+		Public:         req.Public,
+		CoverExhibitID: req.CoverExhibitID,
+		OwnerID:        m.GetLoggedInUserID(r.Context()),
+	}
+	if !m.saveCollection(w, r, &collection, req.ExhibitIDs, true) {
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	rend.JSON(w, r, collection)
+}
+
+// UpdateCollection changes the details and optionally the exhibits of a collection
+func (m *Repository) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := m.getOwnCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var req CollectionForm
+	if err := rend.DecodeJSON(r.Body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+	if err := validator.New().Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+
+	collection.Title = req.Title
+	collection.Description = req.Description
+	collection.Public = req.Public
+	collection.CoverExhibitID = req.CoverExhibitID
+	if !m.saveCollection(w, r, &collection, req.ExhibitIDs, req.ExhibitIDs != nil) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, collection)
+}
+
+// SetCollectionExhibits replaces the exhibits of a collection, in the given order
+func (m *Repository) SetCollectionExhibits(w http.ResponseWriter, r *http.Request) {
+	collection, ok := m.getOwnCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var req CollectionExhibitsForm
+	if err := rend.DecodeJSON(r.Body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+	if err := validator.New().Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+
+	if !m.saveCollection(w, r, &collection, req.ExhibitIDs, true) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, response.OK())
+}
+
+// DeleteCollection deletes a collection, the exhibits in it are kept
+func (m *Repository) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := m.getOwnCollection(w, r)
+	if !ok {
+		return
+	}
+
+	if err := m.App.DB.Delete(&collection).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to delete collection"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPublicCollections lists public collections, most recently updated first
+func (m *Repository) GetPublicCollections(w http.ResponseWriter, r *http.Request) {
+	m.listCollections(w, r, m.App.DB.Model(&models.Collection{}).Where("public = ?", true),
+		func(db *gorm.DB) *gorm.DB { return db.Preload("Owner").Preload("CoverExhibit") })
+}
+
+// listCollections writes a page of the collections query selects, recently
+// updated first by default. preload adds the associations to load.
+func (m *Repository) listCollections(w http.ResponseWriter, r *http.Request, query *gorm.DB, preload func(db *gorm.DB) *gorm.DB) {
+	p, ok := pagingRequest(w, r, collectionOrders, "updated")
+	if !ok {
+		return
+	}
+
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count collections"))
+		return
+	}
+
+	var collections []models.Collection
+	if err = p.Apply(query).Scopes(preload).Find(&collections).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get collections"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "collections", collections, func(c models.Collection) int { return c.ID })
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get collections"))
+		return
+	}
+	page.Total = total
+	for i := range page.Items {
+		m.prepareCollection(r.Context(), &page.Items[i])
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, page)
+}
+
+// GetCollection returns a collection with the exhibits in it the user may
+// see. Private collections are only shown to their owner and admins.
+func (m *Repository) GetCollection(w http.ResponseWriter, r *http.Request) {
+	var cId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &cId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid collection ID"))
+		return
+	}
+
+	var collection models.Collection
+	if err := m.App.DB.Preload("Owner").Preload("CoverExhibit").
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
+		Preload("Items.Exhibit").Preload("Items.Exhibit.Type").Preload("Items.Exhibit.Author").
+		Where("id = ?", cId).Take(&collection).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.Error("collection not found"))
+		return
+	}
+	if !collection.Public && !m.ownsCollection(r.Context(), collection) {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.Error("collection not found"))
+		return
+	}
+
+	m.prepareCollection(r.Context(), &collection)
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, collection)
+}
+
+// ownsCollection reports whether the logged in user owns collection or is an admin
+func (m *Repository) ownsCollection(ctx context.Context, collection models.Collection) bool {
+	if m.isAdmin(ctx) {
+		return true
+	}
+	id := m.GetLoggedInUserID(ctx)
+	return id != 0 && collection.OwnerID == id
+}
+
+// getOwnCollection loads the collection from the URL and makes sure the
+// logged in user may change it
+func (m *Repository) getOwnCollection(w http.ResponseWriter, r *http.Request) (models.Collection, bool) {
+	var collection models.Collection
+
+	var cId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &cId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid collection ID"))
+		return collection, false
+	}
+	if err := m.App.DB.Where("id = ?", cId).Take(&collection).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.Error("collection not found"))
+		return collection, false
+	}
+	if !m.ownsCollection(r.Context(), collection) {
+		w.WriteHeader(http.StatusForbidden)
+		rend.JSON(w, r, response.Error("forbidden"))
+		return collection, false
+	}
+	return collection, true
+}
+
+// saveCollection saves collection and, when replaceItems is set, replaces
+// its exhibits with exhibitIDs. It writes an error response on failure.
+func (m *Repository) saveCollection(w http.ResponseWriter, r *http.Request, collection *models.Collection, exhibitIDs []int, replaceItems bool) bool {
+	if replaceItems {
+		if err := m.checkCollectionExhibits(r.Context(), exhibitIDs); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error(err.Error()))
+			return false
+		}
+	}
+
+	err := m.App.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Owner", "CoverExhibit", "Items").Save(collection).Error; err != nil {
+			return err
+		}
+		if replaceItems {
+			if err := tx.Where("collection_id = ?", collection.ID).Delete(&models.CollectionItem{}).Error; err != nil {
+				return err
+			}
+			if len(exhibitIDs) > 0 {
+				items := make([]models.CollectionItem, len(exhibitIDs))
+				for i, id := range exhibitIDs {
+					items[i] = models.CollectionItem{CollectionID: collection.ID, ExhibitID: id, Position: i}
+				}
+				if err := tx.Omit("Exhibit").Create(&items).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		// The cover has to be one of the exhibits
+		if collection.CoverExhibitID != nil {
+			var count int64
+			if err := tx.Model(&models.CollectionItem{}).
+				Where("collection_id = ? AND exhibit_id = ?", collection.ID, *collection.CoverExhibitID).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return errInvalidCover
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errInvalidCover) {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error(err.Error()))
+		return false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to save collection"))
+		return false
+	}
+	return true
+}
+
+// checkCollectionExhibits makes sure every exhibit exists, is listed once
+// and can be seen by the logged in user
+func (m *Repository) checkCollectionExhibits(ctx context.Context, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	if len(slices.Compact(sorted)) != len(ids) {
+		return errInvalidExhibits
+	}
+
+	var exhibits []models.Exhibit
+	if err := m.App.DB.WithContext(ctx).Where("id IN ?", ids).Find(&exhibits).Error; err != nil {
+		return err
+	}
+	if len(exhibits) != len(ids) {
+		return errInvalidExhibits
+	}
+	for _, exhibit := range exhibits {
+		if !m.canViewExhibit(ctx, exhibit) {
+			return errInvalidExhibits
+		}
+	}
+	return nil
+}
+
+// prepareCollection hides what the logged in user may not see: exhibits
+// in the trash or not visible to them, and private user details
+func (m *Repository) prepareCollection(ctx context.Context, collection *models.Collection) {
+	collection.Owner.Password = ""
+
+	if collection.CoverExhibit != nil {
+		if m.canViewExhibit(ctx, *collection.CoverExhibit) {
+			m.signExhibitURLs(collection.CoverExhibit)
+		} else {
+			collection.CoverExhibit = nil
+		}
+	}
+
+	items := collection.Items[:0]
+	for _, item := range collection.Items {
+		// Exhibits in the trash aren't loaded
+		if item.Exhibit.ID == 0 || !m.canViewExhibit(ctx, item.Exhibit) {
+			continue
+		}
+		item.Exhibit.Author.Password = ""
+		m.signExhibitURLs(&item.Exhibit)
+		items = append(items, item)
+	}
+	collection.Items = items
+}
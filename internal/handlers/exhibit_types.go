@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	rend "github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/media"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+// ExhibitTypeForm creates or updates an exhibit type. An empty
+// allowed_mime_types accepts any content.
+type ExhibitTypeForm struct {
+	Name             string   `json:"name" validate:"required,max=255"`
+	AllowedMimeTypes []string `json:"allowed_mime_types" validate:"max=50,dive,required,max=255,contains=/"`
+	MaxSize          int64    `json:"max_size" validate:"min=0"`
+	Preview          string   `json:"preview" validate:"oneof=asset required generated"`
+}
+
+// CreateExhibitType adds an exhibit type
+func (m *Repository) CreateExhibitType(w http.ResponseWriter, r *http.Request) {
+	var exhibitType models.ExhibitType
+	if !decodeExhibitType(w, r, &exhibitType) {
+		return
+	}
+
+	if err := m.App.DB.Create(&exhibitType).Error; err != nil {
+		w.WriteHeader(http.StatusConflict)
+		rend.JSON(w, r, response.Error("type already exists or failed to create type"))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	rend.JSON(w, r, exhibitType)
+}
+
+// UpdateExhibitType changes the name and upload rules of an exhibit type.
+// The rules apply to later uploads, existing exhibits are kept as they are.
+func (m *Repository) UpdateExhibitType(w http.ResponseWriter, r *http.Request) {
+	exhibitType, ok := m.getExhibitType(w, r)
+	if !ok {
+		return
+	}
+	if !decodeExhibitType(w, r, &exhibitType) {
+		return
+	}
+
+	if err := m.App.DB.Save(&exhibitType).Error; err != nil {
+		w.WriteHeader(http.StatusConflict)
+		rend.JSON(w, r, response.Error("type already exists or failed to update type"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, exhibitType)
+}
+
+// DeleteExhibitType deletes an exhibit type nothing refers to: no exhibit,
+// including the exhibits in the trash, no revision and no saved search
+func (m *Repository) DeleteExhibitType(w http.ResponseWriter, r *http.Request) {
+	exhibitType, ok := m.getExhibitType(w, r)
+	if !ok {
+		return
+	}
+
+	for _, ref := range []struct {
+		query *gorm.DB
+		msg   string
+	}{
+		{m.App.DB.Unscoped().Model(&models.Exhibit{}), "type is used by exhibits"},
+		{m.App.DB.Model(&models.ExhibitRevision{}), "type is used by exhibit revisions"},
+		{m.App.DB.Model(&models.SavedSearch{}), "type is used by saved searches"},
+	} {
+		var count int64
+		if err := ref.query.Where("type_id = ?", exhibitType.ID).Count(&count).Error; err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			rend.JSON(w, r, response.Error("failed to delete type"))
+			return
+		}
+		if count > 0 {
+			w.WriteHeader(http.StatusConflict)
+			rend.JSON(w, r, response.Error(ref.msg))
+			return
+		}
+	}
+
+	if err := m.App.DB.Delete(&exhibitType).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to delete type"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeExhibitType reads an ExhibitTypeForm into exhibitType. It writes the
+// response when the form is invalid.
+func decodeExhibitType(w http.ResponseWriter, r *http.Request, exhibitType *models.ExhibitType) bool {
+	var req ExhibitTypeForm
+	if err := rend.DecodeJSON(r.Body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := validator.New().Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return false
+	}
+
+	allowed := []string{}
+	for _, t := range req.AllowedMimeTypes {
+		allowed = append(allowed, strings.ToLower(strings.TrimSpace(t)))
+	}
+
+	// Renditions are made from the asset, so it has to be an image
+	if req.Preview == models.PreviewFromAsset {
+		if len(allowed) == 0 {
+			allowed = media.PreviewTypes
+		}
+		for _, t := range allowed {
+			if !media.IsAllowed(t, media.PreviewTypes) {
+				w.WriteHeader(http.StatusBadRequest)
+				rend.JSON(w, r, response.Error(fmt.Sprintf("assets that are their own preview must be one of %s", strings.Join(media.PreviewTypes, ", "))))
+				return false
+			}
+		}
+	}
+
+	exhibitType.Name = req.Name
+	exhibitType.AllowedMimeTypes = allowed
+	exhibitType.MaxSize = req.MaxSize
+	exhibitType.Preview = req.Preview
+	return true
+}
+
+// getExhibitType loads the exhibit type from the URL
+func (m *Repository) getExhibitType(w http.ResponseWriter, r *http.Request) (models.ExhibitType, bool) {
+	var exhibitType models.ExhibitType
+
+	var tId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &tId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid type ID"))
+		return exhibitType, false
+	}
+	if err := m.App.DB.Where("id = ?", tId).Take(&exhibitType).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.NotFound("type not found"))
+		return exhibitType, false
+	}
+	return exhibitType, true
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+)
+
+// Facets exhibit listings can be counted by. They name the filter a facet
+// leaves out of its counts.
+const (
+	facetTypes    = "types"
+	facetStatuses = "statuses"
+	facetAuthors  = "authors"
+	facetMonths   = "months"
+)
+
+// maxAuthorFacets caps the number of authors counted, the ones with most
+// exhibits are kept
+const maxAuthorFacets = 20
+
+// FacetCount is the number of exhibits with a value of a facet. Value is
+// what the filter of the facet takes and Label is shown to users.
+type FacetCount struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+	Count int64  `json:"count"`
+}
+
+// Facets counts the exhibits of a listing by type, status, author and month
+// of creation. Statuses are only counted for admins.
+type Facets struct {
+	Types    []FacetCount `json:"types"`
+	Statuses []FacetCount `json:"statuses,omitempty"`
+	Authors  []FacetCount `json:"authors"`
+	Months   []FacetCount `json:"months"`
+}
+
+// ExhibitPage is a page of exhibits with the facet counts, when asked for
+type ExhibitPage struct {
+	paging.Page[models.Exhibit]
+	Facets *Facets `json:"facets,omitempty"`
+}
+
+// exhibitFacets counts the exhibits filtered returns for every facet.
+// filtered leaves the filter of the facet it is given out.
+func exhibitFacets(filtered func(facet string) *gorm.DB, admin bool) (*Facets, error) {
+	facets := &Facets{}
+	counts := []struct {
+		facet  string
+		dest   *[]FacetCount
+		value  string
+		label  string
+		order  string
+		limit  int
+		admins bool
+	}{
+		{facetTypes, &facets.Types, "CAST(exhibit_types.id AS text)", "exhibit_types.name", "count DESC, label", 0, false},
+		{facetStatuses, &facets.Statuses, "exhibit_statuses.name", "exhibit_statuses.name", "count DESC, label", 0, true},
+		{facetAuthors, &facets.Authors, "users.username", "users.username", "count DESC, label", maxAuthorFacets, false},
+		{facetMonths, &facets.Months, "to_char(exhibits.created_at, 'YYYY-MM')", "to_char(exhibits.created_at, 'YYYY-MM')", "value DESC", 0, false},
+	}
+	for _, c := range counts {
+		if c.admins && !admin {
+			continue
+		}
+		*c.dest = []FacetCount{}
+		query := filtered(c.facet).
+			Select(c.value + " AS value, " + c.label + " AS label, COUNT(*) AS count").
+			Group("value, label").
+			Order(c.order)
+		if c.limit > 0 {
+			query = query.Limit(c.limit)
+		}
+		if err := query.Scan(c.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+	return facets, nil
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+func TestExhibitFacets(t *testing.T) {
+	columns := []string{"value", "label", "count"}
+	filter := exhibitFilter{TypeID: "3", Status: "Approved", Username: "ann"}
+
+	tests := []struct {
+		name  string
+		admin bool
+		want  *Facets
+	}{
+		{
+			name: "guest",
+			want: &Facets{
+				Types:   []FacetCount{{"3", "Vase", 2}},
+				Authors: []FacetCount{{"ann", "ann", 2}},
+				Months:  []FacetCount{{"2024-05", "2024-05", 2}},
+			},
+		},
+		{
+			name:  "admin",
+			admin: true,
+			want: &Facets{
+				Types:    []FacetCount{{"3", "Vase", 2}},
+				Statuses: []FacetCount{{"Approved", "Approved", 2}, {"Pending", "Pending", 1}},
+				Authors:  []FacetCount{{"ann", "ann", 2}},
+				Months:   []FacetCount{{"2024-05", "2024-05", 2}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		m, mock := newMockRepo(t)
+
+		// Every facet leaves its own filter out of its counts
+		mock.ExpectQuery(`SELECT CAST\(exhibit_types.id AS text\) AS value, exhibit_types.name AS label, COUNT\(\*\) AS count .* WHERE exhibit_statuses.name = \$1 AND LOWER\(users.username\) LIKE \$2 AND "exhibits"."deleted_at" IS NULL GROUP BY value, label ORDER BY count DESC, label$`).
+			WithArgs("Approved", "%ann%").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("3", "Vase", 2))
+		if tt.admin {
+			mock.ExpectQuery(`SELECT exhibit_statuses.name AS value, .* WHERE LOWER\(users.username\) LIKE \$1 AND exhibit_types.id = \$2 AND "exhibits"."deleted_at" IS NULL GROUP BY value, label ORDER BY count DESC, label$`).
+				WithArgs("%ann%", "3").
+				WillReturnRows(sqlmock.NewRows(columns).AddRow("Approved", "Approved", 2).AddRow("Pending", "Pending", 1))
+		}
+		mock.ExpectQuery(`SELECT users.username AS value, .* WHERE exhibit_statuses.name = \$1 AND exhibit_types.id = \$2 AND "exhibits"."deleted_at" IS NULL GROUP BY value, label ORDER BY count DESC, label LIMIT \$3$`).
+			WithArgs("Approved", "3", maxAuthorFacets).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("ann", "ann", 2))
+		mock.ExpectQuery(`SELECT to_char\(exhibits.created_at, 'YYYY-MM'\) AS value, .* WHERE exhibit_statuses.name = \$1 AND LOWER\(users.username\) LIKE \$2 AND exhibit_types.id = \$3 AND "exhibits"."deleted_at" IS NULL GROUP BY value, label ORDER BY value DESC$`).
+			WithArgs("Approved", "%ann%", "3").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("2024-05", "2024-05", 2))
+
+		got, err := exhibitFacets(func(facet string) *gorm.DB {
+			return filter.apply(exhibitsQuery(m.App.DB), facet)
+		}, tt.admin)
+		if err != nil {
+			t.Fatalf("%s: exhibitFacets() error = %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: exhibitFacets() = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
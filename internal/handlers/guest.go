@@ -7,13 +7,14 @@ import (
 	rend "github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
 	"github.com/seemsod1/ancy/internal/helpers"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
 	"github.com/seemsod1/ancy/internal/lib/api/response"
 	"github.com/seemsod1/ancy/internal/models"
+	"github.com/seemsod1/ancy/internal/search"
 	"golang.org/x/crypto/bcrypt"
-	"io"
+	"gorm.io/gorm"
 	"net/http"
-	"os"
-	"strings"
+	"net/url"
 	"time"
 )
 
@@ -120,24 +121,8 @@ func (m *Repository) SignUp(w http.ResponseWriter, r *http.Request) {
 	} else {
 		defer profilePhoto.Close()
 
-		finalTitle, err := helpers.GenerateHashedFileName(req.Username)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			rend.JSON(w, r, response.Error("failed to generate file name"))
-			return
-		}
 		fileFormat := helpers.GetFileFormat(fileHeader.Filename)
-		profilePhotoPath = finalTitle + "." + fileFormat
-
-		newFile, err := os.Create("storage/users/" + profilePhotoPath)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			rend.JSON(w, r, response.Error("failed to save file"))
-			return
-		}
-		defer newFile.Close()
-
-		_, err = io.Copy(newFile, profilePhoto)
+		profilePhotoPath, err = m.App.Assets.Save(r.Context(), "users/", profilePhoto, fileFormat)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			rend.JSON(w, r, response.Error("failed to save file"))
@@ -162,6 +147,9 @@ func (m *Repository) SignUp(w http.ResponseWriter, r *http.Request) {
 
 	err = m.App.DB.Create(&user).Error
 	if err != nil {
+		if profilePhotoPath != "default.png" {
+			_ = m.App.Assets.Release(r.Context(), "users/"+profilePhotoPath)
+		}
 		w.WriteHeader(http.StatusConflict)
 		rend.JSON(w, r, response.Error("user with this email or username already exists or failed to create user"))
 		return
@@ -184,18 +172,33 @@ func (m *Repository) GetExhibit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var exhibit models.Exhibit
-	if err := m.App.DB.Table("exhibits").Preload("Author").Preload("Type").Preload("Status").Where("id = ?", eId).Take(&exhibit).Error; err != nil {
+	if err := m.App.DB.Table("exhibits").Preload("Author").Preload("Type").Preload("Status").Preload("Tags").Where("id = ?", eId).Take(&exhibit).Error; err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		rend.JSON(w, r, response.Error("exhibit not found"))
 		return
 	}
-	role := m.GetLoggedInUserRole(r.Context())
-	if (role == "User" || role == "") && (exhibit.Status.Name == "Pending" || exhibit.Status.Name == "Rejected") && exhibit.AuthorID != m.GetLoggedInUserID(r.Context()) {
+	if !m.canViewExhibit(r.Context(), exhibit) {
 		w.WriteHeader(http.StatusUnauthorized)
 		rend.JSON(w, r, response.Error("unauthorized"))
 		return
 	}
+	// Views back the popularity sort, authors and reviewing admins don't count
+	if exhibit.AuthorID != m.GetLoggedInUserID(r.Context()) && !m.isAdmin(r.Context()) {
+		if err := m.App.DB.Exec("UPDATE exhibits SET views = views + 1 WHERE id = ?", exhibit.ID).Error; err != nil {
+			m.App.ErrorLog.Println("failed to count exhibit view:", err)
+		}
+	}
 	exhibit.Author.Password = ""
+	m.signExhibitURLs(&exhibit)
+	if m.isAuthorOrAdmin(r.Context(), exhibit) {
+		exhibits := []models.Exhibit{exhibit}
+		if err := m.attachLatestDecisions(r.Context(), exhibits); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			rend.JSON(w, r, response.Error("failed to get moderation decisions"))
+			return
+		}
+		exhibit = exhibits[0]
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(exhibit)
@@ -208,6 +211,16 @@ func (m *Repository) GetAllExhibits(w http.ResponseWriter, r *http.Request) {
 	username := r.URL.Query().Get("username")
 	startDateStr := r.URL.Query().Get("start_date")
 	endDateStr := r.URL.Query().Get("end_date")
+	tsquery := search.ParseQuery(r.URL.Query().Get("q"))
+	tagsParam := r.URL.Query().Get("tags")
+	tagsMatch := r.URL.Query().Get("tags_match")
+	capturedFromStr := r.URL.Query().Get("captured_from")
+	capturedToStr := r.URL.Query().Get("captured_to")
+	bboxParam := r.URL.Query().Get("bbox")
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = "card"
+	}
 
 	var startDate, endDate time.Time
 	var err error
@@ -230,56 +243,171 @@ func (m *Repository) GetAllExhibits(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	dbQuery := m.App.DB.Preload("Type").Preload("Status").Joins("JOIN exhibit_statuses ON exhibits.status_id = exhibit_statuses.id")
-	dbQuery = dbQuery.Joins("JOIN users ON exhibits.author_id = users.id")
-	dbQuery = dbQuery.Joins("JOIN exhibit_types ON exhibits.type_id = exhibit_types.id") // Join with ExhibitType table
+	// Capture dates come from the metadata of the asset, the end date is
+	// included
+	var capturedFrom, capturedTo time.Time
+	if capturedFromStr != "" {
+		if capturedFrom, err = time.Parse("2006-01-02", capturedFromStr); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error("invalid captured_from date format"))
+			return
+		}
+	}
+	if capturedToStr != "" {
+		if capturedTo, err = time.Parse("2006-01-02", capturedToStr); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error("invalid captured_to date format"))
+			return
+		}
+	}
 
-	if status != "" {
-		dbQuery = dbQuery.Where("exhibit_statuses.name = ?", status)
+	var bbox *boundingBox
+	if bboxParam != "" {
+		if bbox, err = parseBoundingBox(bboxParam); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error(err.Error()))
+			return
+		}
 	}
 
-	if username != "" {
-		usernameLower := strings.ToLower(username)
-		dbQuery = dbQuery.Where("LOWER(users.username) LIKE ?", "%"+usernameLower+"%")
+	var tagNames []string
+	if tagsParam != "" {
+		if tagNames, err = parseTags([]string{tagsParam}); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+	}
+	if tagsMatch != "" && tagsMatch != "any" && tagsMatch != "all" {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("tags_match must be any or all"))
+		return
 	}
 
-	if title != "" {
-		titleLower := strings.ToLower(title)
-		dbQuery = dbQuery.Where("LOWER(exhibits.title) LIKE ?", "%"+titleLower+"%")
+	defaultSort := "newest"
+	if tsquery != "" {
+		defaultSort = "relevance"
 	}
-	if typeFilter != "" { // Add condition for type filter
+	p, ok := pagingRequest(w, r, exhibitOrders(tsquery), defaultSort)
+	if !ok {
+		return
+	}
+
+	admin := m.isAdmin(r.Context())
 
-		dbQuery = dbQuery.Where("exhibit_types.id = ?", typeFilter)
+	filter := exhibitFilter{
+		TypeID:    typeFilter,
+		Title:     title,
+		Username:  username,
+		Status:    status,
+		StartDate: startDate,
+		EndDate:   endDate,
 	}
+	// filtered builds the query of the filter set. A facet counts the
+	// exhibits of all filters but its own, so its other values stay
+	// visible after one is picked.
+	filtered := func(facet string) *gorm.DB {
+		dbQuery := filter.apply(exhibitsQuery(m.App.DB), facet)
+
+		if len(tagNames) > 0 {
+			dbQuery = dbQuery.Scopes(taggedWith(tagNames, tagsMatch == "all"))
+		}
+
+		if tsquery != "" {
+			dbQuery = dbQuery.Scopes(search.Matching(tsquery))
+		}
 
-	if !startDate.IsZero() && !endDate.IsZero() {
-		dbQuery = dbQuery.Where("exhibits.created_at BETWEEN ? AND ?", startDate, endDate)
-	} else if !startDate.IsZero() {
-		dbQuery = dbQuery.Where("exhibits.created_at >= ?", startDate)
-	} else if !endDate.IsZero() {
-		dbQuery = dbQuery.Where("exhibits.created_at <= ?", endDate)
+		if !capturedFrom.IsZero() {
+			dbQuery = dbQuery.Where("(exhibits.metadata->>'captured_at')::timestamptz >= ?", capturedFrom)
+		}
+		if !capturedTo.IsZero() {
+			dbQuery = dbQuery.Where("(exhibits.metadata->>'captured_at')::timestamptz < ?", capturedTo.AddDate(0, 0, 1))
+		}
+
+		if bbox != nil {
+			dbQuery = dbQuery.Scopes(bbox.contains)
+		}
+
+		if !admin {
+			dbQuery = dbQuery.Scopes(m.publiclyVisible)
+		}
+		return dbQuery
 	}
+	dbQuery := filtered("")
 
-	userRole := m.GetLoggedInUserRole(r.Context())
-	if userRole == "Admin" && status != "" {
-		dbQuery = dbQuery.Where("exhibit_statuses.name = ?", status)
-	} else if userRole != "Admin" {
-		dbQuery = dbQuery.Where("exhibit_statuses.name = ?", "Approved")
+	total, err := countTotal(dbQuery, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count exhibits"))
+		return
 	}
 
 	var exhibits []models.Exhibit
-	if err = dbQuery.Preload("Author").Find(&exhibits).Error; err != nil {
+	if err = p.Apply(dbQuery).Preload("Type").Preload("Status").Preload("Author").Preload("Tags").Find(&exhibits).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get exhibits"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "exhibits", exhibits, func(e models.Exhibit) int { return e.ID })
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		rend.JSON(w, r, response.Error("failed to get exhibits"))
 		return
 	}
+	page.Total = total
+	exhibits = page.Items
+
+	result := ExhibitPage{Page: page}
+	if r.URL.Query().Get("facets") == "true" {
+		if result.Facets, err = exhibitFacets(filtered, admin); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			rend.JSON(w, r, response.Error("failed to count facets"))
+			return
+		}
+	}
+
+	var highlights map[int]models.SearchHighlight
+	if tsquery != "" {
+		ids := make([]int, len(exhibits))
+		for i, exhibit := range exhibits {
+			ids[i] = exhibit.ID
+		}
+		if highlights, err = search.Highlights(m.App.DB, tsquery, ids); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			rend.JSON(w, r, response.Error("failed to get exhibits"))
+			return
+		}
+	}
+
 	for i := range exhibits {
+		if highlight, ok := highlights[exhibits[i].ID]; ok {
+			exhibits[i].Highlight = &highlight
+		}
 		exhibits[i].Author.Password = ""
+		m.signExhibitURLs(&exhibits[i])
+		// Listings show the preview in the requested rendition, size isn't
+		// covered by the signature
+		if renditionPath(exhibits[i], size) != "" {
+			exhibits[i].PreviewURL += "&size=" + url.QueryEscape(size)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(exhibits)
+	json.NewEncoder(w).Encode(result)
+}
+
+// renditionPath returns the path of the named preview rendition of exhibit
+func renditionPath(exhibit models.Exhibit, size string) string {
+	switch size {
+	case "thumbnail":
+		return exhibit.ThumbnailPath
+	case "card":
+		return exhibit.CardPath
+	case "full":
+		return exhibit.FullPath
+	}
+	return ""
 }
 
 func (m Repository) GetUser(w http.ResponseWriter, r *http.Request) {
@@ -303,13 +431,34 @@ func (m Repository) GetUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Repository) ExhibitTypes(w http.ResponseWriter, r *http.Request) {
+	p, ok := pagingRequest(w, r, exhibitTypeOrders, "name")
+	if !ok {
+		return
+	}
+
+	query := m.App.DB.Model(&models.ExhibitType{})
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count exhibit types"))
+		return
+	}
+
 	var types []models.ExhibitType
-	if err := m.App.DB.Find(&types).Error; err != nil {
+	if err = p.Apply(query).Find(&types).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get exhibit types"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "exhibit_types", types, func(t models.ExhibitType) int { return t.ID })
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		rend.JSON(w, r, response.Error("failed to get exhibit types"))
 		return
 	}
+	page.Total = total
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(types)
+	json.NewEncoder(w).Encode(page)
 }
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"github.com/seemsod1/ancy/internal/assets"
 	"github.com/seemsod1/ancy/internal/config"
+	"github.com/seemsod1/ancy/internal/media"
+	"github.com/seemsod1/ancy/internal/models"
+	"io"
 )
 
 var Repo *Repository
@@ -30,6 +35,143 @@ func (m *Repository) GetLoggedInUserRole(ctx context.Context) string {
 	return role
 }
 
+// isAdmin reports whether the logged in user is an admin. Visibility and
+// ownership checks use it, so other roles never get admin rights.
+func (m *Repository) isAdmin(ctx context.Context) bool {
+	return m.GetLoggedInUserRole(ctx) == "Admin"
+}
+
+// releaseExhibitFiles drops the references an exhibit holds on its files
+func (m *Repository) releaseExhibitFiles(ctx context.Context, exhibit models.Exhibit) error {
+	return m.releaseFiles(ctx, exhibit.FileKeys())
+}
+
+// releaseFiles drops one reference on each of keys
+func (m *Repository) releaseFiles(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := m.App.Assets.Release(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileColumns pairs each file column of exhibit with the column keeping
+// its approved version while a replacement awaits moderation
+func fileColumns(exhibit *models.Exhibit) [][2]*string {
+	return [][2]*string{
+		{&exhibit.AssetPath, &exhibit.PreviousAssetPath},
+		{&exhibit.PreviewPath, &exhibit.PreviousPreviewPath},
+		{&exhibit.ThumbnailPath, &exhibit.PreviousThumbnailPath},
+		{&exhibit.CardPath, &exhibit.PreviousCardPath},
+		{&exhibit.FullPath, &exhibit.PreviousFullPath},
+	}
+}
+
+// hasPreviousFiles reports whether exhibit has a file replacement awaiting moderation
+func hasPreviousFiles(exhibit models.Exhibit) bool {
+	for _, col := range fileColumns(&exhibit) {
+		if *col[1] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// dropPreviousFiles accepts the replaced files of exhibit. It returns the
+// keys of the previous versions, to be released once the change is saved.
+func dropPreviousFiles(exhibit *models.Exhibit) []string {
+	var keys []string
+	for _, col := range fileColumns(exhibit) {
+		if *col[1] != "" {
+			keys = append(keys, *col[1])
+			*col[1] = ""
+		}
+	}
+	exhibit.PreviousMimeType = ""
+	exhibit.PreviousMetadata = nil
+	return keys
+}
+
+// restorePreviousFiles puts the previous versions of replaced files back.
+// It returns the keys of the replacements, to be released once the change
+// is saved.
+func restorePreviousFiles(exhibit *models.Exhibit) []string {
+	var keys []string
+	if exhibit.PreviousAssetPath != "" {
+		exhibit.MimeType = exhibit.PreviousMimeType
+		exhibit.PreviousMimeType = ""
+		exhibit.Metadata = exhibit.PreviousMetadata
+		exhibit.PreviousMetadata = nil
+	}
+	for _, col := range fileColumns(exhibit) {
+		if *col[1] == "" {
+			continue
+		}
+		if *col[0] != "" {
+			keys = append(keys, *col[0])
+		}
+		*col[0], *col[1] = *col[1], ""
+	}
+	return keys
+}
+
+// attachLatestDecisions fills in the latest moderation decision of each exhibit
+func (m *Repository) attachLatestDecisions(ctx context.Context, exhibits []models.Exhibit) error {
+	if len(exhibits) == 0 {
+		return nil
+	}
+	ids := make([]int, len(exhibits))
+	for i, e := range exhibits {
+		ids[i] = e.ID
+	}
+
+	var decisions []models.ModerationDecision
+	if err := m.App.DB.WithContext(ctx).Preload("Reviewer").Preload("Status").
+		Raw("SELECT DISTINCT ON (exhibit_id) * FROM moderation_decisions WHERE exhibit_id IN ? ORDER BY exhibit_id, created_at DESC, id DESC", ids).
+		Find(&decisions).Error; err != nil {
+		return err
+	}
+
+	byExhibit := make(map[int]*models.ModerationDecision, len(decisions))
+	for i := range decisions {
+		decisions[i].Reviewer.Password = ""
+		byExhibit[decisions[i].ExhibitID] = &decisions[i]
+	}
+	for i := range exhibits {
+		exhibits[i].LatestDecision = byExhibit[exhibits[i].ID]
+	}
+	return nil
+}
+
+// stageRenditions stages resized copies of the image in src in batch and
+// records them on exhibit. Images that can't be decoded are skipped, the
+// exhibit then falls back to its preview.
+func (m *Repository) stageRenditions(batch *assets.Batch, exhibit *models.Exhibit, src io.ReadSeeker) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	rendered, err := media.RenderAll(src)
+	if err != nil {
+		m.App.InfoLog.Println("skipping renditions:", err)
+		return nil
+	}
+
+	paths := map[string]*string{
+		"thumbnail": &exhibit.ThumbnailPath,
+		"card":      &exhibit.CardPath,
+		"full":      &exhibit.FullPath,
+	}
+	for _, rendition := range rendered {
+		key, err := batch.Stage(bytes.NewReader(rendition.Data), rendition.Ext)
+		if err != nil {
+			return err
+		}
+		*paths[rendition.Name] = key
+	}
+	return nil
+}
+
 func (m *Repository) GetLoggedInUserID(ctx context.Context) int {
 	userId, _ := m.App.Session.Get(ctx, "user_id").(int)
 	return userId
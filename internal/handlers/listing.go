@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"errors"
+	rend "github.com/go-chi/render"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
+	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/models"
+	"github.com/seemsod1/ancy/internal/search"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exhibitOrders are the sorts of exhibit listings. Searches can also be
+// sorted by relevance to tsquery. Popularity is the views counter, which
+// GetExhibit increments when someone other than the author or an admin
+// opens the exhibit, so reviews don't make exhibits popular.
+func exhibitOrders(tsquery string) map[string]paging.Order {
+	orders := map[string]paging.Order{
+		"newest":     {Columns: []string{"exhibits.created_at", "exhibits.id"}, Desc: true},
+		"oldest":     {Columns: []string{"exhibits.created_at", "exhibits.id"}},
+		"title":      {Columns: []string{"exhibits.title", "exhibits.id"}},
+		"popularity": {Columns: []string{"exhibits.views", "exhibits.id"}, Desc: true},
+	}
+	if tsquery != "" {
+		orders["relevance"] = paging.Order{Columns: []string{search.Rank(tsquery), "exhibits.id"}, Desc: true}
+	}
+	return orders
+}
+
+// userOrders are the sorts of user listings
+var userOrders = map[string]paging.Order{
+	"newest":   {Columns: []string{"users.created_at", "users.id"}, Desc: true},
+	"oldest":   {Columns: []string{"users.created_at", "users.id"}},
+	"username": {Columns: []string{"users.username", "users.id"}},
+}
+
+// collectionOrders are the sorts of collection listings
+var collectionOrders = map[string]paging.Order{
+	"updated": {Columns: []string{"collections.updated_at", "collections.id"}, Desc: true},
+	"newest":  {Columns: []string{"collections.created_at", "collections.id"}, Desc: true},
+	"oldest":  {Columns: []string{"collections.created_at", "collections.id"}},
+	"title":   {Columns: []string{"collections.title", "collections.id"}},
+}
+
+// savedSearchOrders are the sorts of saved search listings
+var savedSearchOrders = map[string]paging.Order{
+	"newest": {Columns: []string{"saved_searches.created_at", "saved_searches.id"}, Desc: true},
+	"oldest": {Columns: []string{"saved_searches.created_at", "saved_searches.id"}},
+	"name":   {Columns: []string{"saved_searches.name", "saved_searches.id"}},
+}
+
+// notificationOrders are the sorts of notification listings
+var notificationOrders = map[string]paging.Order{
+	"newest": {Columns: []string{"notifications.created_at", "notifications.id"}, Desc: true},
+	"oldest": {Columns: []string{"notifications.created_at", "notifications.id"}},
+}
+
+// trashedExhibitOrders are the sorts of the exhibits in the trash
+var trashedExhibitOrders = map[string]paging.Order{
+	"deleted": {Columns: []string{"exhibits.deleted_at", "exhibits.id"}, Desc: true},
+}
+
+// trashedUserOrders are the sorts of the users in the trash
+var trashedUserOrders = map[string]paging.Order{
+	"deleted": {Columns: []string{"users.deleted_at", "users.id"}, Desc: true},
+}
+
+// revisionOrders are the sorts of the revisions of an exhibit
+var revisionOrders = map[string]paging.Order{
+	"newest": {Columns: []string{"exhibit_revisions.created_at", "exhibit_revisions.id"}, Desc: true},
+	"oldest": {Columns: []string{"exhibit_revisions.created_at", "exhibit_revisions.id"}},
+}
+
+// tagOrders are the sorts of tag listings
+var tagOrders = map[string]paging.Order{
+	"name": {Columns: []string{"tags.name", "tags.id"}},
+}
+
+// exhibitTypeOrders are the sorts of exhibit type listings
+var exhibitTypeOrders = map[string]paging.Order{
+	"name": {Columns: []string{"exhibit_types.name", "exhibit_types.id"}},
+}
+
+// userRoleOrders are the sorts of user role listings
+var userRoleOrders = map[string]paging.Order{
+	"name": {Columns: []string{"user_roles.name", "user_roles.id"}},
+}
+
+// queueOrders sorts the moderation queue. Edited exhibits go back to review
+// with a fresh updated_at and join the end of the queue.
+var queueOrders = map[string]paging.Order{
+	"oldest": {Columns: []string{"exhibits.updated_at", "exhibits.id"}},
+}
+
+// pagingRequest reads the paging parameters of r and writes the response
+// when they are invalid
+func pagingRequest(w http.ResponseWriter, r *http.Request, orders map[string]paging.Order, defaultSort string) (paging.Request, bool) {
+	p, err := paging.FromRequest(r, orders, defaultSort)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error(err.Error()))
+		return p, false
+	}
+	return p, true
+}
+
+// countTotal counts the rows of query when the request asks for the total.
+// query must have a model and no paging applied yet.
+func countTotal(query *gorm.DB, p paging.Request) (*int64, error) {
+	if !p.Total {
+		return nil, nil
+	}
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	return &total, nil
+}
+
+// exhibitsQuery starts a query on exhibits joined with the tables
+// exhibitFilter and the facets refer to
+func exhibitsQuery(db *gorm.DB) *gorm.DB {
+	return db.Model(&models.Exhibit{}).
+		Joins("JOIN exhibit_statuses ON exhibits.status_id = exhibit_statuses.id").
+		Joins("JOIN users ON exhibits.author_id = users.id").
+		Joins("JOIN exhibit_types ON exhibits.type_id = exhibit_types.id")
+}
+
+// exhibitFilter holds the filters of exhibit listings that saved searches
+// keep. Empty fields don't filter.
+type exhibitFilter struct {
+	TypeID    string
+	Title     string
+	Username  string
+	Status    string
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// savedSearchFilter returns the filter a saved search keeps
+func savedSearchFilter(s models.SavedSearch) exhibitFilter {
+	f := exhibitFilter{Title: s.Title, Username: s.Username}
+	if s.TypeID != nil {
+		f.TypeID = strconv.Itoa(*s.TypeID)
+	}
+	if s.StartDate != nil {
+		f.StartDate = *s.StartDate
+	}
+	if s.EndDate != nil {
+		f.EndDate = *s.EndDate
+	}
+	return f
+}
+
+// apply adds the filter to a query from exhibitsQuery, leaving out the one
+// of facet
+func (f exhibitFilter) apply(db *gorm.DB, facet string) *gorm.DB {
+	if f.Status != "" && facet != facetStatuses {
+		db = db.Where("exhibit_statuses.name = ?", f.Status)
+	}
+	if f.Username != "" && facet != facetAuthors {
+		db = db.Where("LOWER(users.username) LIKE ?", "%"+strings.ToLower(f.Username)+"%")
+	}
+	if f.Title != "" {
+		db = db.Where("LOWER(exhibits.title) LIKE ?", "%"+strings.ToLower(f.Title)+"%")
+	}
+	if f.TypeID != "" && facet != facetTypes {
+		db = db.Where("exhibit_types.id = ?", f.TypeID)
+	}
+	if facet != facetMonths {
+		if !f.StartDate.IsZero() {
+			db = db.Where("exhibits.created_at >= ?", f.StartDate)
+		}
+		if !f.EndDate.IsZero() {
+			db = db.Where("exhibits.created_at <= ?", f.EndDate)
+		}
+	}
+	return db
+}
+
+// boundingBox is an area of the map in degrees
+type boundingBox struct {
+	minLon, minLat, maxLon, maxLat float64
+}
+
+var errInvalidBoundingBox = errors.New("bbox must be min_lon,min_lat,max_lon,max_lat")
+
+// parseBoundingBox reads a min_lon,min_lat,max_lon,max_lat bounding box.
+// A min_lon greater than max_lon crosses the antimeridian.
+func parseBoundingBox(value string) (*boundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, errInvalidBoundingBox
+	}
+	var coords [4]float64
+	for i, part := range parts {
+		c, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, errInvalidBoundingBox
+		}
+		coords[i] = c
+	}
+	box := &boundingBox{minLon: coords[0], minLat: coords[1], maxLon: coords[2], maxLat: coords[3]}
+	if box.minLat > box.maxLat || box.minLat < -90 || box.maxLat > 90 ||
+		box.minLon < -180 || box.minLon > 180 || box.maxLon < -180 || box.maxLon > 180 {
+		return nil, errInvalidBoundingBox
+	}
+	return box, nil
+}
+
+// contains limits a query on exhibits to the ones whose metadata places
+// them in the box
+func (b *boundingBox) contains(db *gorm.DB) *gorm.DB {
+	lat := "(exhibits.metadata->>'latitude')::float8"
+	lon := "(exhibits.metadata->>'longitude')::float8"
+	db = db.Where(lat+" BETWEEN ? AND ?", b.minLat, b.maxLat)
+	if b.minLon > b.maxLon {
+		return db.Where(lon+" >= ? OR "+lon+" <= ?", b.minLon, b.maxLon)
+	}
+	return db.Where(lon+" BETWEEN ? AND ?", b.minLon, b.maxLon)
+}
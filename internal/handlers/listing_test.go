@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dryRunDB builds SQL without a database
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSavedSearchFilter(t *testing.T) {
+	db := dryRunDB(t)
+	typeID := 3
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		search models.SavedSearch
+		facet  string
+		want   []string
+		// wantNot are conditions the filter must leave out
+		wantNot []string
+	}{
+		{
+			name:    "empty",
+			wantNot: []string{"LIKE", "exhibit_types.id =", "exhibits.created_at"},
+		},
+		{
+			name:   "all fields",
+			search: models.SavedSearch{TypeID: &typeID, Title: "Vase", Username: "Ann", StartDate: &start, EndDate: &end},
+			want: []string{
+				"LOWER(users.username) LIKE '%ann%'",
+				"LOWER(exhibits.title) LIKE '%vase%'",
+				"exhibit_types.id = '3'",
+				"exhibits.created_at >= '2024-01-01 00:00:00'",
+				"exhibits.created_at <= '2024-12-31 00:00:00'",
+			},
+			wantNot: []string{"exhibit_statuses.name"},
+		},
+		{
+			name:    "type facet",
+			search:  models.SavedSearch{TypeID: &typeID, Title: "Vase"},
+			facet:   facetTypes,
+			want:    []string{"LOWER(exhibits.title) LIKE '%vase%'"},
+			wantNot: []string{"exhibit_types.id ="},
+		},
+		{
+			name:    "months facet",
+			search:  models.SavedSearch{Username: "ann", StartDate: &start},
+			facet:   facetMonths,
+			want:    []string{"LOWER(users.username) LIKE '%ann%'"},
+			wantNot: []string{"exhibits.created_at"},
+		},
+	}
+	for _, tt := range tests {
+		sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			var count int64
+			return savedSearchFilter(tt.search).apply(exhibitsQuery(tx), tt.facet).Count(&count)
+		})
+		for _, want := range tt.want {
+			if !strings.Contains(sql, want) {
+				t.Errorf("%s: SQL %q doesn't contain %q", tt.name, sql, want)
+			}
+		}
+		for _, notWant := range tt.wantNot {
+			if strings.Contains(sql, notWant) {
+				t.Errorf("%s: SQL %q contains %q", tt.name, sql, notWant)
+			}
+		}
+	}
+}
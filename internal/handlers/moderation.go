@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	rend "github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
+	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/models"
+	"github.com/seemsod1/ancy/internal/workflow"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"net/http"
+	"time"
+)
+
+// claimTTL is how long a reviewer keeps an exhibit claimed
+const claimTTL = 15 * time.Minute
+
+// errClaimed is returned when another reviewer holds the claim on an exhibit
+var errClaimed = errors.New("exhibit is claimed by another admin")
+
+// ModerationForm is the optional body of ApproveExhibit and RejectExhibit
+type ModerationForm struct {
+	Reason string `json:"reason" validate:"max=1024"`
+}
+
+// ScheduleForm sets the publishing window of an exhibit, a null bound
+// leaves that side of the window open
+type ScheduleForm struct {
+	PublishAt   *time.Time `json:"publish_at"`
+	UnpublishAt *time.Time `json:"unpublish_at"`
+}
+
+// BatchModerationForm decides on many exhibits at once. Decision is the
+// name of a workflow transition, such as approve or reject.
+type BatchModerationForm struct {
+	IDs      []int  `json:"ids" validate:"required,min=1,max=100"`
+	Decision string `json:"decision" validate:"required"`
+	Reason   string `json:"reason" validate:"max=1024"`
+}
+
+// BatchResult is the outcome of a batch decision for one exhibit
+type BatchResult struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// QueueItem is a pending exhibit with the active claim on it, if any
+type QueueItem struct {
+	models.Exhibit
+	Claim *models.ModerationClaim `json:",omitempty"`
+}
+
+func (m *Repository) ApproveExhibit(w http.ResponseWriter, r *http.Request) {
+	m.transitionFromURL(w, r, "approve", "failed to approve exhibit")
+}
+
+func (m *Repository) RejectExhibit(w http.ResponseWriter, r *http.Request) {
+	m.transitionFromURL(w, r, "reject", "failed to reject exhibit")
+}
+
+// ScheduleExhibit sets when an approved exhibit becomes visible to guests
+// and, optionally, when it is hidden again
+func (m *Repository) ScheduleExhibit(w http.ResponseWriter, r *http.Request) {
+	var eId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &eId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid exhibit ID"))
+		return
+	}
+
+	var req ScheduleForm
+	if err := rend.DecodeJSON(r.Body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+	if req.PublishAt != nil && req.UnpublishAt != nil && !req.UnpublishAt.After(*req.PublishAt) {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("unpublish_at must be after publish_at"))
+		return
+	}
+
+	res := m.App.DB.Model(&models.Exhibit{}).Where("id = ?", eId).Updates(map[string]interface{}{
+		"publish_at":   req.PublishAt,
+		"unpublish_at": req.UnpublishAt,
+	})
+	if res.Error != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to schedule exhibit"))
+		return
+	}
+	if res.RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.Error("exhibit not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, response.OK())
+}
+
+// TransitionExhibit performs any workflow transition the logged in user
+// may perform on an exhibit
+func (m *Repository) TransitionExhibit(w http.ResponseWriter, r *http.Request) {
+	m.transitionFromURL(w, r, chi.URLParam(r, "transition"), "failed to change exhibit status")
+}
+
+// transitionFromURL performs the transition called name on the exhibit
+// from the URL on behalf of the logged in user
+func (m *Repository) transitionFromURL(w http.ResponseWriter, r *http.Request, name string, failMsg string) {
+	exhibitID := chi.URLParam(r, "id")
+	if exhibitID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("exhibit ID is required"))
+		return
+	}
+	var eId int
+	if _, err := fmt.Sscanf(exhibitID, "%d", &eId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid exhibit ID"))
+		return
+	}
+
+	var req ModerationForm
+	if err := rend.DecodeJSON(r.Body, &req); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+	if err := validator.New().Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("reason is too long"))
+		return
+	}
+
+	err := m.transitionExhibit(r.Context(), eId, m.GetLoggedInUserID(r.Context()), name, req.Reason)
+	if err != nil {
+		status, msg := transitionError(err, failMsg)
+		w.WriteHeader(status)
+		rend.JSON(w, r, response.Error(msg))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, response.OK())
+}
+
+// transitionError maps an error of transitionExhibit to a status code and message
+func transitionError(err error, failMsg string) (int, string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound, "exhibit not found"
+	case errors.Is(err, errClaimed):
+		return http.StatusConflict, errClaimed.Error()
+	case errors.Is(err, workflow.ErrUnknownTransition):
+		return http.StatusNotFound, "unknown transition"
+	case errors.Is(err, workflow.ErrNotAllowed):
+		return http.StatusConflict, "transition is not allowed from the current status"
+	case errors.Is(err, workflow.ErrForbidden):
+		return http.StatusForbidden, "forbidden"
+	default:
+		return http.StatusInternalServerError, failMsg
+	}
+}
+
+// transitionExhibit performs the named workflow transition on an exhibit
+// on behalf of userID in a transaction of its own
+func (m *Repository) transitionExhibit(ctx context.Context, exhibitID, userID int, name, reason string) error {
+	var released []string
+	err := m.App.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var exhibit models.Exhibit
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", exhibitID).Take(&exhibit).Error; err != nil {
+			return err
+		}
+
+		previous := exhibit
+		var err error
+		if _, released, err = m.applyTransition(ctx, tx, &exhibit, userID, name, reason); err != nil {
+			return err
+		}
+		if _, err = m.recordRevision(tx, previous, userID); err != nil {
+			return err
+		}
+		return tx.Omit(clause.Associations).Save(&exhibit).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = m.releaseFiles(ctx, released); err != nil {
+		m.App.ErrorLog.Println("failed to release replaced files:", err)
+	}
+	if err = m.announceExhibit(ctx, exhibitID); err != nil {
+		m.App.ErrorLog.Println("failed to notify saved searches:", err)
+	}
+	return nil
+}
+
+// applyTransition is where exhibits change status, apart from rollbacks. It
+// performs the named workflow transition on exhibit in tx on behalf of
+// userID and records the decision; saving exhibit is up to the caller.
+// Transitions performed by a reviewer rather than the author drop the claim
+// on the exhibit. It returns the decision and the files to release once tx
+// is committed.
+func (m *Repository) applyTransition(ctx context.Context, tx *gorm.DB, exhibit *models.Exhibit, userID int, name, reason string) (models.ModerationDecision, []string, error) {
+	var decision models.ModerationDecision
+	from, _ := m.App.Workflow.StateByID(exhibit.StatusID)
+	roles := []string{m.GetLoggedInUserRole(ctx)}
+	if exhibit.AuthorID == userID {
+		roles = append(roles, workflow.RoleAuthor)
+	}
+	t, as, err := m.App.Workflow.Transition(from.Name, name, roles...)
+	if err != nil {
+		return decision, nil, err
+	}
+	to, _ := m.App.Workflow.State(t.To)
+	reviewing := as != workflow.RoleAuthor
+	// Publishing again announces the exhibit again, once it is visible
+	if to.Public && !from.Public {
+		exhibit.AnnouncedAt = nil
+	}
+
+	if reviewing {
+		var claims int64
+		if err := tx.Model(&models.ModerationClaim{}).
+			Where("exhibit_id = ? AND reviewer_id <> ? AND expires_at > ?", exhibit.ID, userID, time.Now()).
+			Count(&claims).Error; err != nil {
+			return decision, nil, err
+		}
+		if claims > 0 {
+			return decision, nil, errClaimed
+		}
+		if err := tx.Where("exhibit_id = ?", exhibit.ID).Delete(&models.ModerationClaim{}).Error; err != nil {
+			return decision, nil, err
+		}
+	}
+
+	var released []string
+	switch t.Files {
+	case workflow.FilesAccept:
+		released = dropPreviousFiles(exhibit)
+	case workflow.FilesRestore:
+		released = restorePreviousFiles(exhibit)
+	}
+	exhibit.StatusID = to.ID
+
+	decision = models.ModerationDecision{
+		ExhibitID:  exhibit.ID,
+		ReviewerID: userID,
+		StatusID:   to.ID,
+		Reason:     reason,
+	}
+	return decision, released, tx.Create(&decision).Error
+}
+
+// ModerationQueue lists exhibits awaiting review, the ones waiting longest first.
+// With unclaimed=true exhibits claimed by other admins are left out.
+func (m *Repository) ModerationQueue(w http.ResponseWriter, r *http.Request) {
+	p, ok := pagingRequest(w, r, queueOrders, "oldest")
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	query := m.App.DB.Model(&models.Exhibit{}).Where("exhibits.status_id IN ?", m.App.Workflow.ReviewIDs())
+	if r.URL.Query().Get("unclaimed") == "true" {
+		query = query.Where("NOT EXISTS (SELECT 1 FROM moderation_claims c WHERE c.exhibit_id = exhibits.id AND c.expires_at > ? AND c.reviewer_id <> ?)",
+			now, m.GetLoggedInUserID(r.Context()))
+	}
+
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get exhibits"))
+		return
+	}
+
+	var exhibits []models.Exhibit
+	if err = p.Apply(query).Preload("Author").Preload("Type").Preload("Status").Preload("Tags").
+		Find(&exhibits).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get exhibits"))
+		return
+	}
+	exhibitPage, err := paging.NewPage(m.App.DB, p, "exhibits", exhibits, func(e models.Exhibit) int { return e.ID })
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get exhibits"))
+		return
+	}
+	exhibits = exhibitPage.Items
+
+	ids := make([]int, len(exhibits))
+	for i, e := range exhibits {
+		ids[i] = e.ID
+	}
+	var claims []models.ModerationClaim
+	if len(ids) > 0 {
+		if err := m.App.DB.Preload("Reviewer").Where("exhibit_id IN ? AND expires_at > ?", ids, now).Find(&claims).Error; err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			rend.JSON(w, r, response.Error("failed to get claims"))
+			return
+		}
+	}
+	byExhibit := make(map[int]*models.ModerationClaim, len(claims))
+	for i := range claims {
+		claims[i].Reviewer.Password = ""
+		byExhibit[claims[i].ExhibitID] = &claims[i]
+	}
+
+	items := make([]QueueItem, len(exhibits))
+	for i := range exhibits {
+		exhibits[i].Author.Password = ""
+		m.signExhibitURLs(&exhibits[i])
+		items[i] = QueueItem{Exhibit: exhibits[i], Claim: byExhibit[exhibits[i].ID]}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, paging.Page[QueueItem]{Items: items, NextCursor: exhibitPage.NextCursor, Total: total})
+}
+
+// ClaimExhibit locks an exhibit awaiting review for the logged in admin for claimTTL.
+// Claiming an exhibit again extends the claim.
+func (m *Repository) ClaimExhibit(w http.ResponseWriter, r *http.Request) {
+	var eId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &eId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid exhibit ID"))
+		return
+	}
+
+	var exhibit models.Exhibit
+	if err := m.App.DB.Where("id = ?", eId).Take(&exhibit).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.Error("exhibit not found"))
+		return
+	}
+	if state, _ := m.App.Workflow.StateByID(exhibit.StatusID); !state.Review {
+		w.WriteHeader(http.StatusConflict)
+		rend.JSON(w, r, response.Error("exhibit is not awaiting review"))
+		return
+	}
+
+	now := time.Now()
+	claim := models.ModerationClaim{
+		ExhibitID:  eId,
+		ReviewerID: m.GetLoggedInUserID(r.Context()),
+		ExpiresAt:  now.Add(claimTTL),
+	}
+	// Only expired claims and our own are taken over
+	res := m.App.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "exhibit_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"reviewer_id", "expires_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{clause.Expr{
+			SQL:  "moderation_claims.expires_at <= ? OR moderation_claims.reviewer_id = ?",
+			Vars: []interface{}{now, claim.ReviewerID},
+		}}},
+	}).Create(&claim)
+	if res.Error != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to claim exhibit"))
+		return
+	}
+	if res.RowsAffected == 0 {
+		w.WriteHeader(http.StatusConflict)
+		rend.JSON(w, r, response.Error(errClaimed.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, claim)
+}
+
+// UnclaimExhibit gives up the claim of the logged in admin on an exhibit
+func (m *Repository) UnclaimExhibit(w http.ResponseWriter, r *http.Request) {
+	var eId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &eId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid exhibit ID"))
+		return
+	}
+
+	if err := m.App.DB.Where("exhibit_id = ? AND reviewer_id = ?", eId, m.GetLoggedInUserID(r.Context())).
+		Delete(&models.ModerationClaim{}).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to release claim"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BatchModerate approves or rejects many exhibits in one call. Every
+// exhibit is decided on its own, so the response lists the outcome per ID.
+func (m *Repository) BatchModerate(w http.ResponseWriter, r *http.Request) {
+	var req BatchModerationForm
+	if err := rend.DecodeJSON(r.Body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+	if err := validator.New().Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+
+	reviewerID := m.GetLoggedInUserID(r.Context())
+
+	results := make([]BatchResult, len(req.IDs))
+	for i, id := range req.IDs {
+		results[i] = BatchResult{ID: id, Status: response.StatusOK}
+		if err := m.transitionExhibit(r.Context(), id, reviewerID, req.Decision, req.Reason); err != nil {
+			_, msg := transitionError(err, "failed to moderate exhibit")
+			results[i].Status = response.StatusError
+			results[i].Error = msg
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, results)
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexedwards/scs/v2"
+	"github.com/seemsod1/ancy/internal/models"
+	"github.com/seemsod1/ancy/internal/workflow"
+	"gorm.io/gorm"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTransitionError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantMsg    string
+	}{
+		{gorm.ErrRecordNotFound, http.StatusNotFound, "exhibit not found"},
+		{errClaimed, http.StatusConflict, errClaimed.Error()},
+		{workflow.ErrUnknownTransition, http.StatusNotFound, "unknown transition"},
+		{workflow.ErrNotAllowed, http.StatusConflict, "transition is not allowed from the current status"},
+		{workflow.ErrForbidden, http.StatusForbidden, "forbidden"},
+		{errors.New("connection reset"), http.StatusInternalServerError, "failed"},
+	}
+	for _, tt := range tests {
+		status, msg := transitionError(tt.err, "failed")
+		if status != tt.wantStatus || msg != tt.wantMsg {
+			t.Errorf("transitionError(%v) = %d, %q, want %d, %q", tt.err, status, msg, tt.wantStatus, tt.wantMsg)
+		}
+	}
+}
+
+func TestApplyTransition(t *testing.T) {
+	const (
+		pending  = 1
+		approved = 2
+		rejected = 3
+		authorID = 7
+		adminID  = 9
+	)
+	announced := time.Now()
+	replaced := models.Exhibit{
+		ID: 5, AuthorID: authorID, AnnouncedAt: &announced,
+		AssetPath: "exhibits/new.png", PreviousAssetPath: "exhibits/old.png",
+	}
+
+	tests := []struct {
+		name    string
+		exhibit models.Exhibit
+		userID  int
+		role    string
+		// transition is the name of the transition, reason its reason
+		transition string
+		reason     string
+		// claims counts the claims of other reviewers, -1 when reviewers
+		// aren't checked
+		claims       int
+		wantErr      error
+		wantStatus   int
+		wantReleased []string
+		wantAsset    string
+		wantNoNotice bool
+	}{
+		{
+			name:         "approve accepts the replacement",
+			exhibit:      func() models.Exhibit { e := replaced; e.StatusID = pending; return e }(),
+			userID:       adminID,
+			role:         "Admin",
+			transition:   "approve",
+			reason:       "looks good",
+			wantStatus:   approved,
+			wantReleased: []string{"exhibits/old.png"},
+			wantAsset:    "exhibits/new.png",
+			wantNoNotice: true,
+		},
+		{
+			name:         "reject restores the approved version",
+			exhibit:      func() models.Exhibit { e := replaced; e.StatusID = approved; return e }(),
+			userID:       adminID,
+			role:         "Admin",
+			transition:   "reject",
+			reason:       "blurry",
+			wantStatus:   rejected,
+			wantReleased: []string{"exhibits/new.png"},
+			wantAsset:    "exhibits/old.png",
+		},
+		{
+			name:       "claimed by another admin",
+			exhibit:    func() models.Exhibit { e := replaced; e.StatusID = pending; return e }(),
+			userID:     adminID,
+			role:       "Admin",
+			transition: "approve",
+			claims:     1,
+			wantErr:    errClaimed,
+		},
+		{
+			name:       "author edits",
+			exhibit:    func() models.Exhibit { e := replaced; e.StatusID = approved; return e }(),
+			userID:     authorID,
+			role:       "User",
+			transition: "edit",
+			claims:     -1,
+			wantStatus: pending,
+			wantAsset:  "exhibits/new.png",
+		},
+		{
+			name:       "others can't approve",
+			exhibit:    func() models.Exhibit { e := replaced; e.StatusID = pending; return e }(),
+			userID:     8,
+			role:       "User",
+			transition: "approve",
+			claims:     -1,
+			wantErr:    workflow.ErrForbidden,
+		},
+	}
+	for _, tt := range tests {
+		m, mock := newMockRepo(t)
+		m.App.Workflow.SetID("Pending", pending)
+		m.App.Workflow.SetID("Approved", approved)
+		m.App.Workflow.SetID("Rejected", rejected)
+		m.App.Session = scs.New()
+		ctx, err := m.App.Session.Load(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.App.Session.Put(ctx, "user_role", 2)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT "name" FROM "user_roles" WHERE id = \$1`).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(tt.role))
+		if tt.claims >= 0 {
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "moderation_claims" WHERE exhibit_id = \$1 AND reviewer_id <> \$2 AND expires_at > \$3`).
+				WithArgs(5, tt.userID, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.claims))
+			if tt.claims == 0 {
+				mock.ExpectExec(`DELETE FROM "moderation_claims" WHERE exhibit_id = \$1`).
+					WithArgs(5).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+		}
+		if tt.wantErr == nil {
+			// The decision names the reviewer and the reason
+			mock.ExpectQuery(`INSERT INTO "moderation_decisions" \("exhibit_id","reviewer_id","status_id","reason","created_at"\)`).
+				WithArgs(5, tt.userID, tt.wantStatus, tt.reason, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+
+		exhibit := tt.exhibit
+		var decision models.ModerationDecision
+		var released []string
+		err = m.App.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			decision, released, err = m.applyTransition(ctx, tx, &exhibit, tt.userID, tt.transition, tt.reason)
+			return err
+		})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: applyTransition() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr == nil {
+			if decision.ID != 11 || exhibit.StatusID != tt.wantStatus {
+				t.Errorf("%s: decision %d, status %d, want decision 11, status %d", tt.name, decision.ID, exhibit.StatusID, tt.wantStatus)
+			}
+			if !reflect.DeepEqual(released, tt.wantReleased) {
+				t.Errorf("%s: released = %q, want %q", tt.name, released, tt.wantReleased)
+			}
+			if exhibit.AssetPath != tt.wantAsset {
+				t.Errorf("%s: AssetPath = %q, want %q", tt.name, exhibit.AssetPath, tt.wantAsset)
+			}
+			// Publishing again announces the exhibit again
+			if got := exhibit.AnnouncedAt == nil; got != tt.wantNoNotice {
+				t.Errorf("%s: AnnouncedAt reset = %v, want %v", tt.name, got, tt.wantNoNotice)
+			}
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
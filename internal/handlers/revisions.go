@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	rend "github.com/go-chi/render"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
+	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+)
+
+// recordRevision snapshots exhibit as it was before a change made by
+// userID. It must run in the transaction saving the change.
+func (m *Repository) recordRevision(tx *gorm.DB, exhibit models.Exhibit, userID int) (models.ExhibitRevision, error) {
+	revision := models.ExhibitRevision{
+		ExhibitID:     exhibit.ID,
+		Title:         exhibit.Title,
+		TypeID:        exhibit.TypeID,
+		Description:   exhibit.Description,
+		AssetPath:     exhibit.AssetPath,
+		PreviewPath:   exhibit.PreviewPath,
+		MimeType:      exhibit.MimeType,
+		Metadata:      exhibit.Metadata,
+		ThumbnailPath: exhibit.ThumbnailPath,
+		CardPath:      exhibit.CardPath,
+		FullPath:      exhibit.FullPath,
+		StatusID:      exhibit.StatusID,
+		AuthorID:      userID,
+	}
+	for _, key := range revision.FileKeys() {
+		if err := m.App.Assets.Retain(tx, key); err != nil {
+			return revision, err
+		}
+	}
+	err := tx.Omit(clause.Associations).Create(&revision).Error
+	return revision, err
+}
+
+// GetExhibitRevisions lists the previous versions of an exhibit, newest
+// first. Only the author of the exhibit and admins may see them.
+func (m *Repository) GetExhibitRevisions(w http.ResponseWriter, r *http.Request) {
+	var eId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &eId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid exhibit ID"))
+		return
+	}
+
+	var exhibit models.Exhibit
+	if err := m.App.DB.Where("id = ?", eId).Take(&exhibit).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.Error("exhibit not found"))
+		return
+	}
+	if !m.isAuthorOrAdmin(r.Context(), exhibit) {
+		w.WriteHeader(http.StatusForbidden)
+		rend.JSON(w, r, response.Error("forbidden"))
+		return
+	}
+
+	p, ok := pagingRequest(w, r, revisionOrders, "newest")
+	if !ok {
+		return
+	}
+
+	query := m.App.DB.Model(&models.ExhibitRevision{}).Where("exhibit_id = ?", eId)
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count revisions"))
+		return
+	}
+
+	var revisions []models.ExhibitRevision
+	if err = p.Apply(query).Preload("Type").Preload("Status").Preload("Author").Find(&revisions).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get revisions"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "exhibit_revisions", revisions, func(rev models.ExhibitRevision) int { return rev.ID })
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get revisions"))
+		return
+	}
+	page.Total = total
+	for i := range page.Items {
+		page.Items[i].Author.Password = ""
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, page)
+}
+
+// RollbackExhibit restores the content of an exhibit to one of its
+// revisions, keeping its current status. The current version is recorded as
+// a revision itself, so a rollback can be undone.
+func (m *Repository) RollbackExhibit(w http.ResponseWriter, r *http.Request) {
+	var eId, revisionID int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &eId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid exhibit ID"))
+		return
+	}
+	if _, err := fmt.Sscanf(chi.URLParam(r, "revision"), "%d", &revisionID); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid revision ID"))
+		return
+	}
+
+	var exhibit models.Exhibit
+	if err := m.App.DB.Where("id = ?", eId).Take(&exhibit).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.Error("exhibit not found"))
+		return
+	}
+
+	var revision models.ExhibitRevision
+	if err := m.App.DB.Where("id = ? AND exhibit_id = ?", revisionID, eId).Take(&revision).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.Error("revision not found"))
+		return
+	}
+
+	var released []string
+	err := m.App.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		// Reload the exhibit locked, so it can't change under the rollback
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", eId).Take(&exhibit).Error; err != nil {
+			return err
+		}
+
+		// Pending replacements are dropped along with the current files.
+		// Only the content is rolled back, the status can only change
+		// through the workflow.
+		released = exhibit.FileKeys()
+		restored := exhibit
+		restored.Title = revision.Title
+		restored.TypeID = revision.TypeID
+		restored.Description = revision.Description
+		restored.AssetPath = revision.AssetPath
+		restored.PreviewPath = revision.PreviewPath
+		restored.MimeType = revision.MimeType
+		restored.Metadata = revision.Metadata
+		restored.ThumbnailPath = revision.ThumbnailPath
+		restored.CardPath = revision.CardPath
+		restored.FullPath = revision.FullPath
+		dropPreviousFiles(&restored)
+
+		if _, err := m.recordRevision(tx, exhibit, m.GetLoggedInUserID(r.Context())); err != nil {
+			return err
+		}
+		for _, key := range revision.FileKeys() {
+			if err := m.App.Assets.Retain(tx, key); err != nil {
+				return err
+			}
+		}
+		return tx.Omit(clause.Associations).Save(&restored).Error
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to roll back exhibit"))
+		return
+	}
+
+	if err = m.releaseFiles(r.Context(), released); err != nil {
+		m.App.ErrorLog.Println("failed to release replaced files:", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, response.OK())
+}
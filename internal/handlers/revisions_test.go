@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/seemsod1/ancy/internal/assets"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestRecordRevision(t *testing.T) {
+	m, mock := newMockRepo(t)
+	m.App.Assets = assets.NewStore(m.App.DB, nil)
+	exhibit := models.Exhibit{
+		ID: 5, Title: "Vase", TypeID: 3, StatusID: 2, AuthorID: 7,
+		AssetPath: "exhibits/vase.png", PreviewPath: "exhibits/vase.png", ThumbnailPath: "exhibits/vase-thumb.png",
+		PreviousAssetPath: "exhibits/old.png",
+	}
+
+	// Every file of the revision takes a reference, a file referenced
+	// twice takes two. Pending replacements aren't part of revisions.
+	mock.ExpectBegin()
+	for _, key := range []string{"exhibits/vase.png", "exhibits/vase.png", "exhibits/vase-thumb.png"} {
+		mock.ExpectExec(`INSERT INTO "assets" .* ON CONFLICT \("key"\) DO UPDATE SET "ref_count"=assets.ref_count \+ 1`).
+			WithArgs(key, "", int64(0), 2, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	// The revision is attributed to the user making the change
+	mock.ExpectQuery(`INSERT INTO "exhibit_revisions"`).
+		WithArgs(5, "Vase", 3, "", "exhibits/vase.png", "exhibits/vase.png", "", nil,
+			"exhibits/vase-thumb.png", "", "", 2, 9, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectCommit()
+
+	var revision models.ExhibitRevision
+	err := m.App.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		revision, err = m.recordRevision(tx, exhibit, 9)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("recordRevision() error = %v", err)
+	}
+	if revision.ID != 4 || revision.AuthorID != 9 {
+		t.Errorf("recordRevision() = revision %d by %d, want revision 4 by 9", revision.ID, revision.AuthorID)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
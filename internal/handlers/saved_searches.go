@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	rend "github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
+	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SavedSearchForm saves the filters of GetAllExhibits under a name. Dates
+// use the 2006-01-02 format.
+type SavedSearchForm struct {
+	Name      string `json:"name" validate:"required,max=255"`
+	Type      *int   `json:"type"`
+	Title     string `json:"title" validate:"max=255"`
+	Username  string `json:"username" validate:"max=255"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// GetSavedSearches lists the saved searches of the logged in user
+func (m *Repository) GetSavedSearches(w http.ResponseWriter, r *http.Request) {
+	p, ok := pagingRequest(w, r, savedSearchOrders, "newest")
+	if !ok {
+		return
+	}
+
+	query := m.App.DB.Model(&models.SavedSearch{}).Where("user_id = ?", m.GetLoggedInUserID(r.Context()))
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count saved searches"))
+		return
+	}
+
+	var searches []models.SavedSearch
+	if err = p.Apply(query).Preload("Type").Find(&searches).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get saved searches"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "saved_searches", searches, func(s models.SavedSearch) int { return s.ID })
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get saved searches"))
+		return
+	}
+	page.Total = total
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, page)
+}
+
+// CreateSavedSearch saves a set of filters for the logged in user
+func (m *Repository) CreateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	var req SavedSearchForm
+	if err := rend.DecodeJSON(r.Body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := validator.New().Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+
+	savedSearch := models.SavedSearch{
+		UserID:   m.GetLoggedInUserID(r.Context()),
+		Name:     req.Name,
+		TypeID:   req.Type,
+		Title:    req.Title,
+		Username: req.Username,
+	}
+	for _, d := range []struct {
+		value string
+		dest  **time.Time
+		err   string
+	}{
+		{req.StartDate, &savedSearch.StartDate, "invalid start date format"},
+		{req.EndDate, &savedSearch.EndDate, "invalid end date format"},
+	} {
+		if d.value == "" {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", d.value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error(d.err))
+			return
+		}
+		*d.dest = &date
+	}
+
+	if savedSearch.TypeID != nil {
+		if err := m.App.DB.Where("id = ?", *savedSearch.TypeID).Take(&models.ExhibitType{}).Error; err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error("invalid type"))
+			return
+		}
+	}
+
+	if err := m.App.DB.Create(&savedSearch).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to save search"))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	rend.JSON(w, r, savedSearch)
+}
+
+// DeleteSavedSearch deletes a saved search of the logged in user. Its
+// notifications are kept.
+func (m *Repository) DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	var sId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &sId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid saved search ID"))
+		return
+	}
+
+	res := m.App.DB.Where("id = ? AND user_id = ?", sId, m.GetLoggedInUserID(r.Context())).Delete(&models.SavedSearch{})
+	if res.Error != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to delete saved search"))
+		return
+	}
+	if res.RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.NotFound("saved search not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetNotifications lists the notifications of the logged in user, newest
+// first. With unread=true the ones already read are left out.
+func (m *Repository) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	p, ok := pagingRequest(w, r, notificationOrders, "newest")
+	if !ok {
+		return
+	}
+
+	query := m.App.DB.Model(&models.Notification{}).Where("user_id = ?", m.GetLoggedInUserID(r.Context()))
+	if r.URL.Query().Get("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+	// Exhibits that were hidden or deleted since are left out
+	query = query.Where("notifications.exhibit_id IN (?)",
+		m.App.DB.Model(&models.Exhibit{}).Select("exhibits.id").Scopes(m.visibleTo(r.Context())))
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count notifications"))
+		return
+	}
+
+	var notifications []models.Notification
+	if err = p.Apply(query).Preload("SavedSearch").Preload("Exhibit").Preload("Exhibit.Type").Preload("Exhibit.Author").
+		Find(&notifications).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get notifications"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "notifications", notifications, func(n models.Notification) int { return n.ID })
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get notifications"))
+		return
+	}
+	page.Total = total
+	for i := range page.Items {
+		page.Items[i].Exhibit.Author.Password = ""
+		if m.canViewExhibit(r.Context(), page.Items[i].Exhibit) {
+			m.signExhibitURLs(&page.Items[i].Exhibit)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, page)
+}
+
+// ReadNotification marks a notification of the logged in user as read
+func (m *Repository) ReadNotification(w http.ResponseWriter, r *http.Request) {
+	var nId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &nId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid notification ID"))
+		return
+	}
+
+	res := m.App.DB.Model(&models.Notification{}).
+		Where("id = ? AND user_id = ?", nId, m.GetLoggedInUserID(r.Context())).
+		Update("read_at", gorm.Expr("COALESCE(read_at, ?)", time.Now()))
+	if res.Error != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to read notification"))
+		return
+	}
+	if res.RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.NotFound("notification not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AnnounceExhibits notifies saved searches of the exhibits that became
+// visible to guests and weren't announced yet, such as scheduled exhibits
+// whose publishing window opened. It returns the number of exhibits
+// announced.
+func (m *Repository) AnnounceExhibits(ctx context.Context) (int, error) {
+	var ids []int
+	if err := m.App.DB.WithContext(ctx).Model(&models.Exhibit{}).Scopes(m.publiclyVisible).
+		Where("exhibits.announced_at IS NULL").Pluck("exhibits.id", &ids).Error; err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := m.announceExhibit(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// announceExhibit notifies the users whose saved searches match the exhibit
+// if it is visible to guests and wasn't announced yet. Searches are matched
+// with the filters of GetAllExhibits, authors aren't notified of their own
+// exhibits and every search notifies once per exhibit.
+func (m *Repository) announceExhibit(ctx context.Context, exhibitID int) error {
+	db := m.App.DB.WithContext(ctx)
+
+	var exhibit models.Exhibit
+	err := db.Scopes(m.publiclyVisible).Where("exhibits.id = ? AND exhibits.announced_at IS NULL", exhibitID).Take(&exhibit).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var searches []models.SavedSearch
+	if err = db.Where("user_id <> ? AND (type_id IS NULL OR type_id = ?)", exhibit.AuthorID, exhibit.TypeID).
+		Find(&searches).Error; err != nil {
+		return err
+	}
+
+	var notifications []models.Notification
+	for i := range searches {
+		var count int64
+		if err = savedSearchFilter(searches[i]).apply(exhibitsQuery(db), "").
+			Where("exhibits.id = ?", exhibit.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			notifications = append(notifications, models.Notification{
+				UserID:        searches[i].UserID,
+				SavedSearchID: &searches[i].ID,
+				ExhibitID:     exhibit.ID,
+			})
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if len(notifications) > 0 {
+			if err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&notifications).Error; err != nil {
+				return err
+			}
+		}
+		// UpdateColumn keeps updated_at, which orders the moderation queue
+		return tx.Model(&exhibit).UpdateColumn("announced_at", time.Now()).Error
+	})
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/seemsod1/ancy/internal/config"
+	"github.com/seemsod1/ancy/internal/workflow"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+)
+
+// newMockRepo returns a repository on a mocked database with the default
+// workflow, whose Approved status is public
+func newMockRepo(t *testing.T) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := workflow.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetID("Approved", 2)
+	return NewRepo(&config.AppConfig{DB: db, Workflow: w}), mock
+}
+
+func TestAnnounceExhibit(t *testing.T) {
+	const (
+		exhibitID = 5
+		authorID  = 7
+		typeID    = 3
+	)
+
+	tests := []struct {
+		name string
+		// visible is false when the exhibit isn't visible to guests or was
+		// announced already
+		visible bool
+		// matches tells for every saved search of another user with the
+		// type of the exhibit or none whether its filter matches
+		matches []bool
+		// wantNotified are the saved searches that get a notification
+		wantNotified []int
+	}{
+		{name: "announced", visible: false},
+		{name: "no searches", visible: true},
+		{name: "matching", visible: true, matches: []bool{true, false, true}, wantNotified: []int{1, 3}},
+		{name: "none matching", visible: true, matches: []bool{false}},
+	}
+	for _, tt := range tests {
+		m, mock := newMockRepo(t)
+
+		exhibits := sqlmock.NewRows([]string{"id", "author_id", "type_id", "status_id"})
+		if tt.visible {
+			exhibits.AddRow(exhibitID, authorID, typeID, 2)
+		}
+		mock.ExpectQuery(`SELECT \* FROM "exhibits" WHERE \(exhibits.id = \$1 AND exhibits.announced_at IS NULL\) AND exhibits.status_id IN \(\$2\)`).
+			WithArgs(exhibitID, 2, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+			WillReturnRows(exhibits)
+
+		if tt.visible {
+			// Authors aren't notified of their own exhibits and searches
+			// for other types don't match
+			searches := sqlmock.NewRows([]string{"id", "user_id", "type_id"})
+			for i := range tt.matches {
+				searches.AddRow(i+1, 10+i, nil)
+			}
+			mock.ExpectQuery(`SELECT \* FROM "saved_searches" WHERE user_id <> \$1 AND \(type_id IS NULL OR type_id = \$2\)`).
+				WithArgs(authorID, typeID).
+				WillReturnRows(searches)
+			for _, match := range tt.matches {
+				count := 0
+				if match {
+					count = 1
+				}
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "exhibits" JOIN .* WHERE exhibits.id = \$1`).
+					WithArgs(exhibitID).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+			}
+
+			mock.ExpectBegin()
+			if len(tt.wantNotified) > 0 {
+				// Every search notifies once per exhibit
+				var args []driver.Value
+				rows := sqlmock.NewRows([]string{"id"})
+				for _, id := range tt.wantNotified {
+					args = append(args, 10+id-1, id, exhibitID, nil, sqlmock.AnyArg())
+					rows.AddRow(id)
+				}
+				mock.ExpectQuery(`INSERT INTO "notifications" .* ON CONFLICT DO NOTHING RETURNING "id"`).
+					WithArgs(args...).
+					WillReturnRows(rows)
+			}
+			mock.ExpectExec(`UPDATE "exhibits" SET "announced_at"=\$1 WHERE "exhibits"."deleted_at" IS NULL AND "id" = \$2`).
+				WithArgs(sqlmock.AnyArg(), exhibitID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}
+
+		if err := m.announceExhibit(context.Background(), exhibitID); err != nil {
+			t.Errorf("%s: announceExhibit() error = %v", tt.name, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
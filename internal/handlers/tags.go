@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	rend "github.com/go-chi/render"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
+	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTagLength   = 64
+	maxExhibitTags = 20
+)
+
+var errInvalidTags = fmt.Errorf("tags must be at most %d characters long and at most %d per exhibit", maxTagLength, maxExhibitTags)
+
+// TagForm renames a tag
+type TagForm struct {
+	Name string `json:"name"`
+}
+
+// parseTags reads tag names from form values. Every value may hold several
+// comma separated names. Names are trimmed, lower-cased and deduplicated.
+func parseTags(values []string) ([]string, error) {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			name = normalizeTag(name)
+			if name == "" || seen[name] {
+				continue
+			}
+			if utf8.RuneCountInString(name) > maxTagLength {
+				return nil, errInvalidTags
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) > maxExhibitTags {
+		return nil, errInvalidTags
+	}
+	return names, nil
+}
+
+// normalizeTag lower-cases name and collapses its whitespace
+func normalizeTag(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// findOrCreateTags returns the tags called names, creating the missing ones
+func findOrCreateTags(tx *gorm.DB, names []string) ([]models.Tag, error) {
+	tags := []models.Tag{}
+	if len(names) == 0 {
+		return tags, nil
+	}
+	missing := make([]models.Tag, len(names))
+	for i, name := range names {
+		missing[i] = models.Tag{Name: name}
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&missing).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("name IN ?", names).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// taggedWith limits a query on exhibits to the ones tagged with any or, when
+// all is set, every one of names
+func taggedWith(names []string, all bool) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		tagged := db.Session(&gorm.Session{NewDB: true}).Table("exhibit_tags").
+			Select("exhibit_tags.exhibit_id").
+			Joins("JOIN tags ON tags.id = exhibit_tags.tag_id").
+			Where("tags.name IN ?", names)
+		if all {
+			tagged = tagged.Group("exhibit_tags.exhibit_id").Having("COUNT(DISTINCT tags.id) = ?", len(names))
+		}
+		return db.Where("exhibits.id IN (?)", tagged)
+	}
+}
+
+// TagCloud lists the tags of the exhibits guests can see with the number of
+// exhibits for each, most used first. limit caps the number of tags.
+func (m *Repository) TagCloud(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error("invalid limit"))
+			return
+		}
+	}
+
+	cloud := []models.TagCount{}
+	if err := m.App.DB.Table("tags").
+		Select("tags.id, tags.name, COUNT(exhibits.id) AS count").
+		Joins("JOIN exhibit_tags ON exhibit_tags.tag_id = tags.id").
+		Joins("JOIN exhibits ON exhibits.id = exhibit_tags.exhibit_id AND exhibits.deleted_at IS NULL").
+		Scopes(m.publiclyVisible).
+		Group("tags.id").
+		Order("count DESC, tags.name").
+		Limit(limit).
+		Scan(&cloud).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get tags"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, cloud)
+}
+
+// GetAllTags lists every tag with the number of its exhibits, including the
+// tags without visible exhibits
+func (m *Repository) GetAllTags(w http.ResponseWriter, r *http.Request) {
+	p, ok := pagingRequest(w, r, tagOrders, "name")
+	if !ok {
+		return
+	}
+
+	query := m.App.DB.Model(&models.Tag{})
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count tags"))
+		return
+	}
+
+	var tags []models.TagCount
+	if err = p.Apply(query).
+		Select("tags.id, tags.name, COUNT(exhibit_tags.exhibit_id) AS count").
+		Joins("LEFT JOIN exhibit_tags ON exhibit_tags.tag_id = tags.id").
+		Group("tags.id").
+		Scan(&tags).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get tags"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "tags", tags, func(t models.TagCount) int { return t.ID })
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get tags"))
+		return
+	}
+	page.Total = total
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, page)
+}
+
+// RenameTag renames a tag. Renaming to the name of another tag is refused,
+// such tags are merged instead.
+func (m *Repository) RenameTag(w http.ResponseWriter, r *http.Request) {
+	tag, ok := m.getTag(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req TagForm
+	if err := rend.DecodeJSON(r.Body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+	name := normalizeTag(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxTagLength {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error(fmt.Sprintf("name must be 1 to %d characters long", maxTagLength)))
+		return
+	}
+
+	var count int64
+	if err := m.App.DB.Model(&models.Tag{}).Where("name = ? AND id <> ?", name, tag.ID).Count(&count).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to rename tag"))
+		return
+	}
+	if count > 0 {
+		w.WriteHeader(http.StatusConflict)
+		rend.JSON(w, r, response.Error("tag with this name already exists, merge the tags instead"))
+		return
+	}
+
+	tag.Name = name
+	if err := m.App.DB.Save(&tag).Error; err != nil {
+		w.WriteHeader(http.StatusConflict)
+		rend.JSON(w, r, response.Error("failed to rename tag"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, tag)
+}
+
+// MergeTag moves the exhibits of a tag to the target tag and deletes it
+func (m *Repository) MergeTag(w http.ResponseWriter, r *http.Request) {
+	tag, ok := m.getTag(w, r, "id")
+	if !ok {
+		return
+	}
+	target, ok := m.getTag(w, r, "target")
+	if !ok {
+		return
+	}
+	if tag.ID == target.ID {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("can't merge a tag into itself"))
+		return
+	}
+
+	err := m.App.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		// Exhibits that already have the target tag keep a single link
+		if err := tx.Exec(`INSERT INTO exhibit_tags (exhibit_id, tag_id)
+			SELECT exhibit_id, ? FROM exhibit_tags WHERE tag_id = ?
+			ON CONFLICT DO NOTHING`, target.ID, tag.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM exhibit_tags WHERE tag_id = ?", tag.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&tag).Error
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to merge tags"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, target)
+}
+
+// getTag loads the tag whose ID is in the URL parameter param
+func (m *Repository) getTag(w http.ResponseWriter, r *http.Request, param string) (models.Tag, bool) {
+	var tag models.Tag
+
+	var tId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, param), "%d", &tId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid tag ID"))
+		return tag, false
+	}
+	if err := m.App.DB.Where("id = ?", tId).Take(&tag).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.NotFound("tag not found"))
+		return tag, false
+	}
+	return tag, true
+}
+
+// sameTags reports whether tags are exactly the tags called names
+func sameTags(tags []models.Tag, names []string) bool {
+	if len(tags) != len(names) {
+		return false
+	}
+	for _, tag := range tags {
+		if !slices.Contains(names, tag.Name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tooMany := make([]string, maxExhibitTags+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("a", i+1)
+	}
+
+	tests := []struct {
+		name    string
+		values  []string
+		want    []string
+		wantErr error
+	}{
+		{"none", nil, []string{}, nil},
+		{"comma separated", []string{"vase, Bronze Age", "coin"}, []string{"vase", "bronze age", "coin"}, nil},
+		{"normalized duplicates", []string{"Vase", " vase ", "bronze   age,Bronze Age"}, []string{"vase", "bronze age"}, nil},
+		{"empty names", []string{",, ,", ""}, []string{}, nil},
+		{"longest name", []string{strings.Repeat("ї", maxTagLength)}, []string{strings.Repeat("ї", maxTagLength)}, nil},
+		{"too long", []string{strings.Repeat("a", maxTagLength+1)}, nil, errInvalidTags},
+		{"too many", tooMany, nil, errInvalidTags},
+	}
+	for _, tt := range tests {
+		got, err := parseTags(tt.values)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: parseTags() error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseTags() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSameTags(t *testing.T) {
+	tags := []models.Tag{{Name: "vase"}, {Name: "coin"}}
+
+	tests := []struct {
+		name  string
+		names []string
+		want  bool
+	}{
+		{"same", []string{"vase", "coin"}, true},
+		{"other order", []string{"coin", "vase"}, true},
+		{"missing", []string{"vase"}, false},
+		{"different", []string{"vase", "bronze"}, false},
+	}
+	for _, tt := range tests {
+		if got := sameTags(tags, tt.names); got != tt.want {
+			t.Errorf("%s: sameTags() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTaggedWith(t *testing.T) {
+	db := dryRunDB(t)
+
+	tests := []struct {
+		name string
+		all  bool
+		want string
+	}{
+		{
+			name: "any",
+			want: `exhibits.id IN (SELECT exhibit_tags.exhibit_id FROM "exhibit_tags" JOIN tags ON tags.id = exhibit_tags.tag_id WHERE tags.name IN ('vase','coin'))`,
+		},
+		{
+			name: "all",
+			all:  true,
+			want: `exhibits.id IN (SELECT exhibit_tags.exhibit_id FROM "exhibit_tags" JOIN tags ON tags.id = exhibit_tags.tag_id WHERE tags.name IN ('vase','coin') GROUP BY "exhibit_tags"."exhibit_id" HAVING COUNT(DISTINCT tags.id) = 2)`,
+		},
+	}
+	for _, tt := range tests {
+		sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			var exhibits []models.Exhibit
+			return tx.Scopes(taggedWith([]string{"vase", "coin"}, tt.all)).Find(&exhibits)
+		})
+		if !strings.Contains(sql, tt.want) {
+			t.Errorf("%s: SQL %q doesn't contain %q", tt.name, sql, tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	rend "github.com/go-chi/render"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
+	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// TrashPage is a page of deleted exhibits or users that can still be restored
+type TrashPage[T any] struct {
+	paging.Page[T]
+	// Retention is how long items stay in the trash before they are purged
+	Retention string `json:"retention"`
+}
+
+// GetTrashedExhibits lists deleted exhibits, most recently deleted first
+func (m *Repository) GetTrashedExhibits(w http.ResponseWriter, r *http.Request) {
+	p, ok := pagingRequest(w, r, trashedExhibitOrders, "deleted")
+	if !ok {
+		return
+	}
+
+	query := m.App.DB.Unscoped().Model(&models.Exhibit{}).Where("exhibits.deleted_at IS NOT NULL")
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count exhibits"))
+		return
+	}
+
+	unscoped := func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped()
+	}
+	var exhibits []models.Exhibit
+	if err = p.Apply(query).Preload("Author", unscoped).Preload("Type").Preload("Status").Find(&exhibits).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get exhibits"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "exhibits", exhibits, func(e models.Exhibit) int { return e.ID })
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get exhibits"))
+		return
+	}
+	page.Total = total
+	for i := range page.Items {
+		page.Items[i].Author.Password = ""
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, TrashPage[models.Exhibit]{Page: page, Retention: m.App.Env.TrashRetention.String()})
+}
+
+// GetTrashedUsers lists deleted users, most recently deleted first
+func (m *Repository) GetTrashedUsers(w http.ResponseWriter, r *http.Request) {
+	p, ok := pagingRequest(w, r, trashedUserOrders, "deleted")
+	if !ok {
+		return
+	}
+
+	query := m.App.DB.Unscoped().Model(&models.User{}).Where("users.deleted_at IS NOT NULL")
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count users"))
+		return
+	}
+
+	var users []models.User
+	if err = p.Apply(query).Preload("Role").Find(&users).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get users"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "users", users, func(u models.User) int { return u.ID })
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get users"))
+		return
+	}
+	page.Total = total
+	for i := range page.Items {
+		page.Items[i].Password = ""
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, TrashPage[models.User]{Page: page, Retention: m.App.Env.TrashRetention.String()})
+}
+
+// RestoreExhibit takes an exhibit out of the trash. Exhibits of users in the
+// trash come back with RestoreUser.
+func (m *Repository) RestoreExhibit(w http.ResponseWriter, r *http.Request) {
+	var eId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &eId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid exhibit ID"))
+		return
+	}
+
+	var exhibit models.Exhibit
+	if err := m.App.DB.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", eId).Take(&exhibit).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.NotFound("exhibit not found in trash"))
+		return
+	}
+
+	// The author is checked in the update, so it can't be trashed meanwhile
+	res := m.App.DB.Unscoped().Model(&exhibit).
+		Where("author_id IN (SELECT id FROM users WHERE deleted_at IS NULL)").
+		Update("deleted_at", nil)
+	if res.Error != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to restore exhibit"))
+		return
+	}
+	if res.RowsAffected == 0 {
+		w.WriteHeader(http.StatusConflict)
+		rend.JSON(w, r, response.Error("the author is in the trash, restore the author instead"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, response.OK())
+}
+
+// RestoreUser takes a user out of the trash, together with the exhibits
+// that were deleted along with them
+func (m *Repository) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	var uId int
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &uId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid user ID"))
+		return
+	}
+
+	var user models.User
+	if err := m.App.DB.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", uId).Take(&user).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.NotFound("user not found in trash"))
+		return
+	}
+
+	deletedAt := user.DeletedAt.Time
+	err := m.App.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.Exhibit{}).
+			Where("author_id = ? AND deleted_at = ?", user.ID, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&user).Update("deleted_at", nil).Error
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to restore user"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rend.JSON(w, r, response.OK())
+}
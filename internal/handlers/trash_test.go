@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withURLParam returns r with the chi URL parameter key set to value
+func withURLParam(r *http.Request, key, value string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add(key, value)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestRestoreExhibit(t *testing.T) {
+	tests := []struct {
+		name    string
+		trashed bool
+		// restored is the number of rows the update changes, 0 when the
+		// author is in the trash
+		restored   int64
+		wantStatus int
+	}{
+		{"not in trash", false, 0, http.StatusNotFound},
+		{"author in trash", true, 0, http.StatusConflict},
+		{"restored", true, 1, http.StatusOK},
+	}
+	for _, tt := range tests {
+		m, mock := newMockRepo(t)
+
+		rows := sqlmock.NewRows([]string{"id", "author_id", "deleted_at"})
+		if tt.trashed {
+			rows.AddRow(5, 7, time.Now())
+		}
+		mock.ExpectQuery(`SELECT \* FROM "exhibits" WHERE id = \$1 AND deleted_at IS NOT NULL LIMIT \$2`).
+			WithArgs(5, 1).
+			WillReturnRows(rows)
+		if tt.trashed {
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE "exhibits" SET "deleted_at"=\$1,"updated_at"=\$2 WHERE author_id IN \(SELECT id FROM users WHERE deleted_at IS NULL\) AND "id" = \$3`).
+				WithArgs(nil, sqlmock.AnyArg(), 5).
+				WillReturnResult(sqlmock.NewResult(0, tt.restored))
+			mock.ExpectCommit()
+		}
+
+		rec := httptest.NewRecorder()
+		m.RestoreExhibit(rec, withURLParam(httptest.NewRequest(http.MethodPost, "/", nil), "id", "5"))
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	m, mock := newMockRepo(t)
+	deletedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE id = \$1 AND deleted_at IS NOT NULL LIMIT \$2`).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "deleted_at"}).AddRow(7, deletedAt))
+	// Only the exhibits deleted along with the user come back
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "exhibits" SET "deleted_at"=\$1,"updated_at"=\$2 WHERE author_id = \$3 AND deleted_at = \$4`).
+		WithArgs(nil, sqlmock.AnyArg(), 7, deletedAt).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE "users" SET "deleted_at"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+		WithArgs(nil, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := httptest.NewRecorder()
+	m.RestoreUser(rec, withURLParam(httptest.NewRequest(http.MethodPost, "/", nil), "id", "7"))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
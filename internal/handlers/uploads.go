@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5"
+	rend "github.com/go-chi/render"
+	"github.com/seemsod1/ancy/internal/lib/api/response"
+	"github.com/seemsod1/ancy/internal/models"
+	"github.com/seemsod1/ancy/internal/uploads"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Resumable uploads follow the tus 1.0 core protocol with the creation,
+// expiration and termination extensions. A finished upload is turned into
+// an exhibit with FinalizeUpload.
+const (
+	tusVersion      = "1.0.0"
+	tusExtensions   = "creation,expiration,termination"
+	maxUploadLength = 4 << 30
+)
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func setUploadHeaders(w http.ResponseWriter, upload models.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+}
+
+// UploadOptions advertises the supported tus version and extensions
+func (m *Repository) UploadOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(maxUploadLength))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Repository) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid upload length"))
+		return
+	}
+	if length > maxUploadLength {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		rend.JSON(w, r, response.Error("file too big"))
+		return
+	}
+
+	metadata := r.Header.Get("Upload-Metadata")
+	if _, err = uploads.ParseMetadata(metadata); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid upload metadata"))
+		return
+	}
+
+	upload, err := m.App.Uploads.Create(r.Context(), m.GetLoggedInUserID(r.Context()), length, metadata)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to create upload"))
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	w.Header().Set("Location", "/api/v1/user/uploads/"+upload.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// getUpload loads the upload from the URL owned by the logged in user
+func (m *Repository) getUpload(w http.ResponseWriter, r *http.Request) (models.Upload, bool) {
+	upload, err := m.App.Uploads.Get(r.Context(), chi.URLParam(r, "id"), m.GetLoggedInUserID(r.Context()))
+	if errors.Is(err, uploads.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.NotFound("upload not found"))
+		return upload, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get upload"))
+		return upload, false
+	}
+	return upload, true
+}
+
+func (m *Repository) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+
+	upload, ok := m.getUpload(w, r)
+	if !ok {
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *Repository) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		rend.JSON(w, r, response.Error("content type must be application/offset+octet-stream"))
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid upload offset"))
+		return
+	}
+
+	upload, ok := m.getUpload(w, r)
+	if !ok {
+		return
+	}
+
+	err = m.App.Uploads.Append(r.Context(), &upload, offset, r.Body)
+	switch {
+	case errors.Is(err, uploads.ErrOffsetMismatch):
+		w.WriteHeader(http.StatusConflict)
+		rend.JSON(w, r, response.Error("upload offset mismatch"))
+		return
+	case errors.Is(err, uploads.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		rend.JSON(w, r, response.NotFound("upload not found"))
+		return
+	case errors.Is(err, uploads.ErrTooLarge):
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		rend.JSON(w, r, response.Error("chunk exceeds upload length"))
+		return
+	case err != nil:
+		// The received part of the chunk is kept, the client resumes from HEAD
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to write chunk"))
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Repository) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+
+	upload, ok := m.getUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if err := m.App.Uploads.Remove(r.Context(), upload); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to delete upload"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FinalizeUpload turns a completed upload into a pending exhibit. It takes
+// the same form as CreateExhibit without the file field; title, type,
+// description and tags fall back to the upload metadata.
+func (m *Repository) FinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rend.JSON(w, r, response.Error("file too big"))
+			return
+		}
+	} else if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rend.JSON(w, r, response.Error("invalid request"))
+		return
+	}
+
+	upload, ok := m.getUpload(w, r)
+	if !ok {
+		return
+	}
+	if upload.Offset != upload.Length {
+		w.WriteHeader(http.StatusConflict)
+		rend.JSON(w, r, response.Error("upload is not complete"))
+		return
+	}
+
+	metadata, _ := uploads.ParseMetadata(upload.Metadata)
+	for _, key := range []string{"title", "type", "description", "tags"} {
+		if r.Form.Get(key) == "" && metadata[key] != "" {
+			r.Form.Set(key, metadata[key])
+		}
+	}
+
+	file, err := m.App.Uploads.Open(upload)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get file"))
+		return
+	}
+	defer file.Close()
+
+	if !m.createExhibit(w, r, file, metadata["filename"]) {
+		return
+	}
+
+	if err = m.App.Uploads.Remove(r.Context(), upload); err != nil {
+		m.App.ErrorLog.Println("failed to remove finalized upload:", err)
+	}
+}
@@ -4,21 +4,42 @@ import (
 	"github.com/go-chi/chi/v5"
 	rend "github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
+	"github.com/seemsod1/ancy/internal/lib/api/paging"
 	"github.com/seemsod1/ancy/internal/lib/api/response"
 	"github.com/seemsod1/ancy/internal/models"
 	"net/http"
 )
 
 func (m *Repository) GetAllUserRoles(w http.ResponseWriter, r *http.Request) {
-	var req []models.UserRole
+	p, ok := pagingRequest(w, r, userRoleOrders, "name")
+	if !ok {
+		return
+	}
 
-	if err := m.App.DB.Find(&req).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	query := m.App.DB.Model(&models.UserRole{})
+	total, err := countTotal(query, p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to count roles"))
+		return
+	}
+
+	var roles []models.UserRole
+	if err = p.Apply(query).Find(&roles).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get roles"))
+		return
+	}
+	page, err := paging.NewPage(m.App.DB, p, "user_roles", roles, func(role models.UserRole) int { return role.ID })
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to get roles"))
 		return
 	}
+	page.Total = total
 
 	//render all roles
-	rend.JSON(w, r, req)
+	rend.JSON(w, r, page)
 }
 
 func (m *Repository) CreateUserRole(w http.ResponseWriter, r *http.Request) {
@@ -26,3 +26,12 @@ func (m *Repository) Exhibit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+func (m *Repository) Collection(w http.ResponseWriter, r *http.Request) {
+
+	err := render.Template(w, r, "collection.page.tmpl", &models.TemplateData{})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		rend.JSON(w, r, response.Error("failed to render page"))
+		return
+	}
+}
@@ -1,8 +1,6 @@
 package helpers
 
 import (
-	"fmt"
-	"golang.org/x/crypto/bcrypt"
 	"strings"
 )
 
@@ -13,13 +11,3 @@ func GetFileFormat(fileName string) string {
 	}
 	return "" // No file format detected
 }
-
-func GenerateHashedFileName(title string) (string, error) {
-	finalTitle, err := bcrypt.GenerateFromPassword([]byte(title), bcrypt.DefaultCost)
-	if err != nil {
-
-		return "", err
-	}
-
-	return fmt.Sprintf("%x", finalTitle), nil
-}
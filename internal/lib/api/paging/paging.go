@@ -0,0 +1,152 @@
+package paging
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultLimit is the page size when the request doesn't set one
+	DefaultLimit = 20
+	// MaxLimit caps the page size
+	MaxLimit = 100
+)
+
+var (
+	ErrInvalidLimit  = errors.New("invalid limit")
+	ErrInvalidSort   = errors.New("invalid sort")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Order is a way to sort a listing. Columns are SQL expressions on the
+// listed table, all sorted in the same direction. The last one has to be
+// unique and none may be NULL, so rows can be resumed after a cursor.
+type Order struct {
+	Columns []string
+	Desc    bool
+}
+
+// Page is the response of list endpoints. NextCursor is empty on the last
+// page and Total is only counted when the request asks for it.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// Request holds the paging parameters of a list request: limit, sort, the
+// after cursor and whether total=true was asked for
+type Request struct {
+	Limit int
+	Sort  string
+	Total bool
+	order Order
+	after []string
+}
+
+// cursor is encoded into the opaque after parameter. It keeps the values of
+// the order columns of the last row of a page.
+type cursor struct {
+	Sort string   `json:"s"`
+	Keys []string `json:"k"`
+}
+
+// FromRequest reads the paging parameters of r. orders lists the sorts the
+// endpoint supports, defaultSort is used when r doesn't pick one.
+func FromRequest(r *http.Request, orders map[string]Order, defaultSort string) (Request, error) {
+	q := r.URL.Query()
+	p := Request{Limit: DefaultLimit, Sort: defaultSort, Total: q.Get("total") == "true"}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return p, ErrInvalidLimit
+		}
+		p.Limit = limit
+	}
+
+	if v := q.Get("sort"); v != "" {
+		p.Sort = v
+	}
+	order, ok := orders[p.Sort]
+	if !ok {
+		return p, ErrInvalidSort
+	}
+	p.order = order
+
+	if v := q.Get("after"); v != "" {
+		data, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return p, ErrInvalidCursor
+		}
+		var c cursor
+		if err = json.Unmarshal(data, &c); err != nil || c.Sort != p.Sort || len(c.Keys) != len(order.Columns) {
+			return p, ErrInvalidCursor
+		}
+		p.after = c.Keys
+	}
+	return p, nil
+}
+
+// Apply sorts db and skips the rows up to the cursor. It fetches one row
+// more than the limit, which tells NewPage whether there is a next page.
+func (p Request) Apply(db *gorm.DB) *gorm.DB {
+	dir, cmp := "ASC", ">"
+	if p.order.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	for _, column := range p.order.Columns {
+		db = db.Order(column + " " + dir)
+	}
+	if p.after != nil {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(p.after)), ", ")
+		args := make([]interface{}, len(p.after))
+		for i, key := range p.after {
+			args[i] = key
+		}
+		db = db.Where("("+strings.Join(p.order.Columns, ", ")+") "+cmp+" ("+placeholders+")", args...)
+	}
+	return db.Limit(p.Limit + 1)
+}
+
+// NewPage builds the page from the rows fetched with Apply. The cursor is
+// made from the order columns of the last item, looked up in table by the
+// ID the id function returns.
+func NewPage[T any](db *gorm.DB, p Request, table string, items []T, id func(T) int) (Page[T], error) {
+	if items == nil {
+		items = []T{}
+	}
+	page := Page[T]{Items: items}
+	if len(items) <= p.Limit {
+		return page, nil
+	}
+	page.Items = items[:p.Limit]
+
+	values := make([]sql.NullString, len(p.order.Columns))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := db.Table(table).Select(strings.Join(p.order.Columns, ", ")).
+		Where(table+".id = ?", id(page.Items[p.Limit-1])).
+		Row().Scan(dest...); err != nil {
+		return page, err
+	}
+
+	c := cursor{Sort: p.Sort, Keys: make([]string, len(values))}
+	for i, v := range values {
+		c.Keys[i] = v.String
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return page, err
+	}
+	page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+	return page, nil
+}
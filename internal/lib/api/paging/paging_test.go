@@ -0,0 +1,140 @@
+package paging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var orders = map[string]Order{
+	"newest": {Columns: []string{"exhibits.created_at", "exhibits.id"}, Desc: true},
+	"title":  {Columns: []string{"exhibits.title", "exhibits.id"}},
+}
+
+// encoded builds the after parameter NewPage would hand out for c
+func encoded(t *testing.T, c cursor) string {
+	t.Helper()
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestFromRequest(t *testing.T) {
+	valid := encoded(t, cursor{Sort: "title", Keys: []string{"Кіт, \"чорний\"", "42"}})
+
+	tests := []struct {
+		name      string
+		query     string
+		want      Request
+		wantAfter []string
+		wantErr   error
+	}{
+		{name: "defaults", query: "", want: Request{Limit: DefaultLimit, Sort: "newest"}},
+		{name: "limit", query: "limit=5", want: Request{Limit: 5, Sort: "newest"}},
+		{name: "max limit", query: "limit=100", want: Request{Limit: MaxLimit, Sort: "newest"}},
+		{name: "total", query: "total=true", want: Request{Limit: DefaultLimit, Sort: "newest", Total: true}},
+		{name: "total not true", query: "total=1", want: Request{Limit: DefaultLimit, Sort: "newest"}},
+		{name: "sort", query: "sort=title", want: Request{Limit: DefaultLimit, Sort: "title"}},
+		{name: "cursor", query: "sort=title&after=" + valid, want: Request{Limit: DefaultLimit, Sort: "title"}, wantAfter: []string{"Кіт, \"чорний\"", "42"}},
+		{name: "zero limit", query: "limit=0", wantErr: ErrInvalidLimit},
+		{name: "negative limit", query: "limit=-1", wantErr: ErrInvalidLimit},
+		{name: "limit too large", query: "limit=101", wantErr: ErrInvalidLimit},
+		{name: "limit not a number", query: "limit=ten", wantErr: ErrInvalidLimit},
+		{name: "unknown sort", query: "sort=views", wantErr: ErrInvalidSort},
+		{name: "cursor not base64", query: "after=***", wantErr: ErrInvalidCursor},
+		{name: "cursor padded", query: "sort=title&after=" + valid + "==", wantErr: ErrInvalidCursor},
+		{name: "cursor not JSON", query: "after=" + base64.RawURLEncoding.EncodeToString([]byte("not json")), wantErr: ErrInvalidCursor},
+		{name: "cursor of another sort", query: "after=" + valid, wantErr: ErrInvalidCursor},
+		{name: "cursor missing keys", query: "sort=title&after=" + encoded(t, cursor{Sort: "title", Keys: []string{"42"}}), wantErr: ErrInvalidCursor},
+		{name: "cursor extra keys", query: "sort=title&after=" + encoded(t, cursor{Sort: "title", Keys: []string{"a", "b", "c"}}), wantErr: ErrInvalidCursor},
+		{name: "cursor without keys", query: "sort=title&after=" + encoded(t, cursor{Sort: "title"}), wantErr: ErrInvalidCursor},
+		{name: "cursor with wrong types", query: "sort=title&after=" + base64.RawURLEncoding.EncodeToString([]byte(`{"s":"title","k":[1,2]}`)), wantErr: ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/exhibits?"+tt.query, nil)
+		got, err := FromRequest(r, orders, "newest")
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: FromRequest() error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got.Limit != tt.want.Limit || got.Sort != tt.want.Sort || got.Total != tt.want.Total {
+			t.Errorf("%s: FromRequest() = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(got.after, tt.wantAfter) {
+			t.Errorf("%s: FromRequest() after = %q, want %q", tt.name, got.after, tt.wantAfter)
+		}
+		if !reflect.DeepEqual(got.order, orders[got.Sort]) {
+			t.Errorf("%s: FromRequest() order = %+v, want %+v", tt.name, got.order, orders[got.Sort])
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "first page",
+			query: "limit=10",
+			want:  `SELECT * FROM "exhibits" ORDER BY exhibits.created_at DESC,exhibits.id DESC LIMIT 11`,
+		},
+		{
+			name:  "after cursor",
+			query: "sort=title&after=" + encoded(t, cursor{Sort: "title", Keys: []string{"Cat", "7"}}),
+			want:  `SELECT * FROM "exhibits" WHERE (exhibits.title, exhibits.id) > ('Cat', '7') ORDER BY exhibits.title ASC,exhibits.id ASC LIMIT 21`,
+		},
+	}
+	for _, tt := range tests {
+		p, err := FromRequest(httptest.NewRequest("GET", "/?"+tt.query, nil), orders, "newest")
+		if err != nil {
+			t.Fatalf("%s: FromRequest() error = %v", tt.name, err)
+		}
+		got := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			var rows []map[string]interface{}
+			return p.Apply(tx.Table("exhibits")).Find(&rows)
+		})
+		if got != tt.want {
+			t.Errorf("%s: Apply() SQL =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewPageLastPage(t *testing.T) {
+	p := Request{Limit: 2, Sort: "title", order: orders["title"]}
+	tests := []struct {
+		name  string
+		items []int
+		want  []int
+	}{
+		{"nil", nil, []int{}},
+		{"empty", []int{}, []int{}},
+		{"full", []int{1, 2}, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		// The last page needs no cursor, so the database isn't queried
+		page, err := NewPage(nil, p, "exhibits", tt.items, func(id int) int { return id })
+		if err != nil {
+			t.Fatalf("%s: NewPage() error = %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(page.Items, tt.want) || page.NextCursor != "" {
+			t.Errorf("%s: NewPage() = %+v, want items %v without cursor", tt.name, page, tt.want)
+		}
+	}
+}
@@ -1,8 +1,9 @@
 package response
 
 type Response struct {
-	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Details interface{} `json:"details,omitempty"`
 }
 
 const (
@@ -23,6 +24,14 @@ func Error(msg string) Response {
 	}
 }
 
+func ErrorWithDetails(msg string, details interface{}) Response {
+	return Response{
+		Status:  StatusError,
+		Error:   msg,
+		Details: details,
+	}
+}
+
 func NotFound(msg string) Response {
 	return Response{
 		Status: StatusError,
@@ -0,0 +1,67 @@
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrMissing = errors.New("signedurl: missing signature")
+	ErrExpired = errors.New("signedurl: url expired")
+	ErrInvalid = errors.New("signedurl: invalid signature")
+)
+
+// Signer creates and checks URLs carrying an expiry and an HMAC-SHA256
+// signature of their path and expiry
+type Signer struct {
+	key []byte
+	ttl time.Duration
+}
+
+func New(key []byte, ttl time.Duration) *Signer {
+	return &Signer{
+		key: key,
+		ttl: ttl,
+	}
+}
+
+func (s *Signer) signature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns path with expires and signature query parameters, valid
+// until now plus the signer TTL
+func (s *Signer) Sign(path string, now time.Time) string {
+	expires := now.Add(s.ttl).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", s.signature(path, expires))
+	return path + "?" + query.Encode()
+}
+
+// Verify checks the signature of path in query. Other query parameters are
+// not covered by the signature.
+func (s *Signer) Verify(path string, query url.Values, now time.Time) error {
+	signature := query.Get("signature")
+	if signature == "" {
+		return ErrMissing
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(path, expires))) {
+		return ErrInvalid
+	}
+	if now.Unix() > expires {
+		return ErrExpired
+	}
+	return nil
+}
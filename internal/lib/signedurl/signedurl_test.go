@@ -0,0 +1,60 @@
+package signedurl
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	signer := New([]byte("secret"), time.Hour)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	signed := signer.Sign("/api/v1/exhibits/1/asset", now)
+	path, rawQuery, _ := strings.Cut(signed, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(key, value string) url.Values {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		if value == "" {
+			q.Del(key)
+		} else {
+			q.Set(key, value)
+		}
+		return q
+	}
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		path   string
+		query  url.Values
+		now    time.Time
+		want   error
+	}{
+		{"valid", signer, path, query, now, nil},
+		{"valid until expiry", signer, path, query, now.Add(time.Hour), nil},
+		{"extra parameters", signer, path, with("download", "1"), now, nil},
+		{"expired", signer, path, query, now.Add(time.Hour + time.Second), ErrExpired},
+		{"missing signature", signer, path, with("signature", ""), now, ErrMissing},
+		{"missing expiry", signer, path, with("expires", ""), now, ErrInvalid},
+		{"malformed expiry", signer, path, with("expires", "soon"), now, ErrInvalid},
+		{"extended expiry", signer, path, with("expires", "4102444800"), now.Add(2 * time.Hour), ErrInvalid},
+		{"tampered signature", signer, path, with("signature", "A"+query.Get("signature")[1:]), now, ErrInvalid},
+		{"other path", signer, "/api/v1/exhibits/2/asset", query, now, ErrInvalid},
+		{"other key", New([]byte("other"), time.Hour), path, query, now, ErrInvalid},
+	}
+	for _, tt := range tests {
+		if err := tt.signer.Verify(tt.path, tt.query, tt.now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/seemsod1/ancy/internal/models"
+	"io"
+	"strings"
+	"time"
+)
+
+// maxEXIFSize caps the EXIF block read from PNG and WebP files, JPEG
+// segments can't be larger than 64 KiB anyway
+const maxEXIFSize = 1 << 20
+
+var errInvalidEXIF = errors.New("media: invalid EXIF data")
+
+// EXIF tags read from the TIFF structure
+const (
+	tagMake              = 0x010f
+	tagModel             = 0x0110
+	tagExifIFD           = 0x8769
+	tagGPSIFD            = 0x8825
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+	tagOffsetTimeOrig    = 0x9011
+	tagGPSLatitudeRef    = 0x0001
+	tagGPSLatitude       = 0x0002
+	tagGPSLongitudeRef   = 0x0003
+	tagGPSLongitude      = 0x0004
+)
+
+// EXIF field types
+const (
+	typeASCII    = 2
+	typeShort    = 3
+	typeLong     = 4
+	typeRational = 5
+)
+
+// readEXIF finds the EXIF block of a JPEG, PNG or WebP image and fills in
+// the camera, capture time and location
+func readEXIF(r io.ReadSeeker, mimeType string, meta *models.MediaMetadata) error {
+	var data []byte
+	var err error
+	switch mimeType {
+	case "image/jpeg":
+		data, err = jpegEXIF(r)
+	case "image/png":
+		data, err = pngEXIF(r)
+	case "image/webp":
+		data, err = webpEXIF(r)
+	}
+	if err != nil || data == nil {
+		return err
+	}
+	return parseEXIF(data, meta)
+}
+
+// jpegEXIF returns the TIFF structure of the APP1 segment of a JPEG
+func jpegEXIF(r io.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi != [2]byte{0xff, 0xd8} {
+		return nil, errInvalidEXIF
+	}
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil, err
+		}
+		// The image data starts at SOS, metadata comes before it
+		if marker[0] != 0xff || marker[1] == 0xda || marker[1] == 0xd9 {
+			return nil, nil
+		}
+		size := int(binary.BigEndian.Uint16(marker[2:4])) - 2
+		if size < 0 {
+			return nil, errInvalidEXIF
+		}
+		segment := make([]byte, size)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+		if marker[1] == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// pngEXIF returns the eXIf chunk of a PNG
+func pngEXIF(r io.ReadSeeker) ([]byte, error) {
+	var signature [8]byte
+	if _, err := io.ReadFull(r, signature[:]); err != nil {
+		return nil, err
+	}
+	if string(signature[:]) != "\x89PNG\r\n\x1a\n" {
+		return nil, errInvalidEXIF
+	}
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		switch string(header[4:8]) {
+		case "eXIf":
+			if size > maxEXIFSize {
+				return nil, errInvalidEXIF
+			}
+			data := make([]byte, size)
+			_, err := io.ReadFull(r, data)
+			return data, err
+		case "IEND":
+			return nil, nil
+		}
+		// Skip the data and the CRC
+		if _, err := r.Seek(size+4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// webpEXIF returns the EXIF chunk of a WebP
+func webpEXIF(r io.ReadSeeker) ([]byte, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return nil, errInvalidEXIF
+	}
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		if string(chunk[0:4]) == "EXIF" {
+			if size > maxEXIFSize {
+				return nil, errInvalidEXIF
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			// Some writers keep the JPEG prefix
+			return bytes.TrimPrefix(data, []byte("Exif\x00\x00")), nil
+		}
+		// Chunks are word aligned
+		if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tiff reads the IFDs of a TIFF structure
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is a field of an IFD
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// parseEXIF reads the camera from IFD0, the capture time from the Exif IFD
+// and the location from the GPS IFD
+func parseEXIF(data []byte, meta *models.MediaMetadata) error {
+	if len(data) < 8 {
+		return errInvalidEXIF
+	}
+	t := tiff{data: data}
+	switch string(data[0:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return errInvalidEXIF
+	}
+
+	ifd0, err := t.ifd(t.order.Uint32(data[4:8]))
+	if err != nil {
+		return err
+	}
+	meta.CameraMake = t.ascii(ifd0[tagMake])
+	meta.CameraModel = t.ascii(ifd0[tagModel])
+
+	if offset, ok := t.long(ifd0[tagExifIFD]); ok {
+		if exif, err := t.ifd(offset); err == nil {
+			meta.CapturedAt = exifTime(t.ascii(exif[tagDateTimeOriginal]), t.ascii(exif[tagOffsetTimeOrig]))
+			if meta.CapturedAt == nil {
+				meta.CapturedAt = exifTime(t.ascii(exif[tagDateTimeDigitized]), "")
+			}
+		}
+	}
+
+	if offset, ok := t.long(ifd0[tagGPSIFD]); ok {
+		if gps, err := t.ifd(offset); err == nil {
+			lat, latOK := t.degrees(gps[tagGPSLatitude])
+			lon, lonOK := t.degrees(gps[tagGPSLongitude])
+			if latOK && lonOK && lat <= 90 && lon <= 180 {
+				if t.ascii(gps[tagGPSLatitudeRef]) == "S" {
+					lat = -lat
+				}
+				if t.ascii(gps[tagGPSLongitudeRef]) == "W" {
+					lon = -lon
+				}
+				meta.Latitude, meta.Longitude = &lat, &lon
+			}
+		}
+	}
+	return nil
+}
+
+// ifd reads the entries of the IFD at offset keyed by tag
+func (t tiff) ifd(offset uint32) (map[uint16]ifdEntry, error) {
+	if int64(offset)+2 > int64(len(t.data)) {
+		return nil, errInvalidEXIF
+	}
+	count := int(t.order.Uint16(t.data[offset:]))
+	start := int64(offset) + 2
+	if start+int64(count)*12 > int64(len(t.data)) {
+		return nil, errInvalidEXIF
+	}
+
+	entries := make(map[uint16]ifdEntry, count)
+	for i := 0; i < count; i++ {
+		e := t.data[start+int64(i)*12:]
+		entry := ifdEntry{typ: t.order.Uint16(e[2:4]), count: t.order.Uint32(e[4:8])}
+		var size int64
+		switch entry.typ {
+		case typeShort:
+			size = 2
+		case typeLong:
+			size = 4
+		case typeRational:
+			size = 8
+		default:
+			size = 1
+		}
+		size *= int64(entry.count)
+		// Values up to four bytes are kept in the entry itself
+		if size <= 4 {
+			entry.value = e[8 : 8+size]
+		} else {
+			valueOffset := int64(t.order.Uint32(e[8:12]))
+			if valueOffset+size > int64(len(t.data)) {
+				continue
+			}
+			entry.value = t.data[valueOffset : valueOffset+size]
+		}
+		entries[t.order.Uint16(e[0:2])] = entry
+	}
+	return entries, nil
+}
+
+// ascii returns the trimmed text of an ASCII field
+func (t tiff) ascii(e ifdEntry) string {
+	if e.typ != typeASCII {
+		return ""
+	}
+	text, _, _ := strings.Cut(string(e.value), "\x00")
+	return strings.TrimSpace(text)
+}
+
+// long returns the value of a LONG field, such as the offset of an IFD
+func (t tiff) long(e ifdEntry) (uint32, bool) {
+	if e.typ != typeLong || len(e.value) < 4 {
+		return 0, false
+	}
+	return t.order.Uint32(e.value), true
+}
+
+// degrees converts a degrees, minutes and seconds GPS field to degrees
+func (t tiff) degrees(e ifdEntry) (float64, bool) {
+	if e.typ != typeRational || len(e.value) < 24 {
+		return 0, false
+	}
+	var parts [3]float64
+	for i := range parts {
+		num := t.order.Uint32(e.value[i*8:])
+		den := t.order.Uint32(e.value[i*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+	return parts[0] + parts[1]/60 + parts[2]/3600, true
+}
+
+// exifTime parses an EXIF date. Without offset, which older cameras don't
+// record, the local time of the camera is taken as UTC.
+func exifTime(value, offset string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	layout := "2006:01:02 15:04:05"
+	if offset != "" {
+		value, layout = value+offset, layout+"-07:00"
+	}
+	captured, err := time.Parse(layout, value)
+	if err != nil || captured.Year() < 1800 {
+		return nil
+	}
+	captured = captured.UTC()
+	return &captured
+}
@@ -0,0 +1,329 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/seemsod1/ancy/internal/models"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// testEntry is an IFD field for tiffData. Entries with ifd set point to the
+// IFD with that index.
+type testEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+	ifd   int
+}
+
+func asciiEntry(tag uint16, s string) testEntry {
+	return testEntry{tag: tag, typ: typeASCII, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func rationalEntry(order binary.ByteOrder, tag uint16, values ...uint32) testEntry {
+	value := make([]byte, 4*len(values))
+	for i, v := range values {
+		order.PutUint32(value[i*4:], v)
+	}
+	return testEntry{tag: tag, typ: typeRational, count: uint32(len(values) / 2), value: value}
+}
+
+// tiffData lays out ifds one after another, followed by the values that
+// don't fit into their entries
+func tiffData(order binary.ByteOrder, ifds ...[]testEntry) []byte {
+	offsets := make([]uint32, len(ifds))
+	pos := uint32(8)
+	for i, ifd := range ifds {
+		offsets[i] = pos
+		pos += 2 + 12*uint32(len(ifd)) + 4
+	}
+	valuesStart := pos
+
+	data := make([]byte, pos)
+	if order == binary.LittleEndian {
+		copy(data, "II*\x00")
+	} else {
+		copy(data, "MM\x00*")
+	}
+	order.PutUint32(data[4:], 8)
+	var values []byte
+	for i, ifd := range ifds {
+		p := offsets[i]
+		order.PutUint16(data[p:], uint16(len(ifd)))
+		p += 2
+		for _, e := range ifd {
+			if e.ifd > 0 {
+				e.typ, e.count, e.value = typeLong, 1, make([]byte, 4)
+				order.PutUint32(e.value, offsets[e.ifd])
+			}
+			order.PutUint16(data[p:], e.tag)
+			order.PutUint16(data[p+2:], e.typ)
+			order.PutUint32(data[p+4:], e.count)
+			if len(e.value) <= 4 {
+				copy(data[p+8:], e.value)
+			} else {
+				order.PutUint32(data[p+8:], valuesStart+uint32(len(values)))
+				values = append(values, e.value...)
+			}
+			p += 12
+		}
+	}
+	return append(data, values...)
+}
+
+// photoEXIF is the EXIF of a photo taken in Kyiv
+func photoEXIF(order binary.ByteOrder) []byte {
+	return tiffData(order,
+		[]testEntry{
+			asciiEntry(tagMake, "Canon"),
+			asciiEntry(tagModel, "EOS"),
+			{tag: tagExifIFD, ifd: 1},
+			{tag: tagGPSIFD, ifd: 2},
+		},
+		[]testEntry{
+			asciiEntry(tagDateTimeOriginal, "2021:06:15 14:30:00"),
+			asciiEntry(tagOffsetTimeOrig, "+03:00"),
+		},
+		[]testEntry{
+			asciiEntry(tagGPSLatitudeRef, "N"),
+			rationalEntry(order, tagGPSLatitude, 50, 1, 27, 1, 36, 100),
+			asciiEntry(tagGPSLongitudeRef, "E"),
+			rationalEntry(order, tagGPSLongitude, 30, 1, 31, 1, 2424, 100),
+		},
+	)
+}
+
+func checkPhotoEXIF(t *testing.T, name string, meta *models.MediaMetadata) {
+	t.Helper()
+	if meta.CameraMake != "Canon" || meta.CameraModel != "EOS" {
+		t.Errorf("%s: camera = %q %q, want Canon EOS", name, meta.CameraMake, meta.CameraModel)
+	}
+	if want := time.Date(2021, 6, 15, 11, 30, 0, 0, time.UTC); meta.CapturedAt == nil || !meta.CapturedAt.Equal(want) {
+		t.Errorf("%s: captured at %v, want %v", name, meta.CapturedAt, want)
+	}
+	if meta.Latitude == nil || meta.Longitude == nil ||
+		math.Abs(*meta.Latitude-50.4501) > 1e-9 || math.Abs(*meta.Longitude-30.5234) > 1e-9 {
+		t.Errorf("%s: location = %v, %v, want 50.4501, 30.5234", name, meta.Latitude, meta.Longitude)
+	}
+}
+
+func TestParseEXIF(t *testing.T) {
+	for name, order := range map[string]binary.ByteOrder{"little endian": binary.LittleEndian, "big endian": binary.BigEndian} {
+		meta := &models.MediaMetadata{}
+		if err := parseEXIF(photoEXIF(order), meta); err != nil {
+			t.Fatalf("%s: parseEXIF() error = %v", name, err)
+		}
+		checkPhotoEXIF(t, name, meta)
+	}
+}
+
+func TestParseEXIFFields(t *testing.T) {
+	le := binary.LittleEndian
+	tests := []struct {
+		name     string
+		data     []byte
+		captured *time.Time
+		lat, lon *float64
+	}{
+		{
+			name: "southern and western",
+			data: tiffData(le,
+				[]testEntry{{tag: tagGPSIFD, ifd: 1}},
+				[]testEntry{
+					asciiEntry(tagGPSLatitudeRef, "S"),
+					rationalEntry(le, tagGPSLatitude, 33, 1, 51, 1, 0, 1),
+					asciiEntry(tagGPSLongitudeRef, "W"),
+					rationalEntry(le, tagGPSLongitude, 70, 1, 30, 1, 0, 1),
+				}),
+			lat: ptr(-33.85), lon: ptr(-70.5),
+		},
+		{
+			name: "without offset",
+			data: tiffData(le,
+				[]testEntry{{tag: tagExifIFD, ifd: 1}},
+				[]testEntry{asciiEntry(tagDateTimeOriginal, "2021:06:15 14:30:00")}),
+			captured: ptr(time.Date(2021, 6, 15, 14, 30, 0, 0, time.UTC)),
+		},
+		{
+			name: "digitized",
+			data: tiffData(le,
+				[]testEntry{{tag: tagExifIFD, ifd: 1}},
+				[]testEntry{
+					asciiEntry(tagDateTimeOriginal, "0000:00:00 00:00:00"),
+					asciiEntry(tagDateTimeDigitized, "2020:01:02 03:04:05"),
+				}),
+			captured: ptr(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)),
+		},
+		{
+			name: "latitude out of range",
+			data: tiffData(le,
+				[]testEntry{{tag: tagGPSIFD, ifd: 1}},
+				[]testEntry{
+					rationalEntry(le, tagGPSLatitude, 91, 1, 0, 1, 0, 1),
+					rationalEntry(le, tagGPSLongitude, 30, 1, 0, 1, 0, 1),
+				}),
+		},
+		{
+			name: "zero denominator",
+			data: tiffData(le,
+				[]testEntry{{tag: tagGPSIFD, ifd: 1}},
+				[]testEntry{
+					rationalEntry(le, tagGPSLatitude, 50, 0, 0, 1, 0, 1),
+					rationalEntry(le, tagGPSLongitude, 30, 1, 0, 1, 0, 1),
+				}),
+		},
+		{
+			name: "latitude without longitude",
+			data: tiffData(le,
+				[]testEntry{{tag: tagGPSIFD, ifd: 1}},
+				[]testEntry{rationalEntry(le, tagGPSLatitude, 50, 1, 0, 1, 0, 1)}),
+		},
+		{
+			name: "wrong field types",
+			data: tiffData(le,
+				[]testEntry{
+					{tag: tagExifIFD, typ: typeShort, count: 1, value: []byte{8, 0}},
+					{tag: tagMake, typ: typeLong, count: 1, value: []byte("abcd")},
+				}),
+		},
+	}
+	for _, tt := range tests {
+		meta := &models.MediaMetadata{}
+		if err := parseEXIF(tt.data, meta); err != nil {
+			t.Fatalf("%s: parseEXIF() error = %v", tt.name, err)
+		}
+		if (meta.CapturedAt == nil) != (tt.captured == nil) || tt.captured != nil && !meta.CapturedAt.Equal(*tt.captured) {
+			t.Errorf("%s: captured at %v, want %v", tt.name, meta.CapturedAt, tt.captured)
+		}
+		if !sameFloat(meta.Latitude, tt.lat) || !sameFloat(meta.Longitude, tt.lon) {
+			t.Errorf("%s: location = %v, %v, want %v, %v", tt.name, meta.Latitude, meta.Longitude, tt.lat, tt.lon)
+		}
+		if meta.CameraMake != "" {
+			t.Errorf("%s: camera make = %q", tt.name, meta.CameraMake)
+		}
+	}
+}
+
+func TestParseEXIFTruncated(t *testing.T) {
+	le := binary.LittleEndian
+	full := photoEXIF(le)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  bool
+	}{
+		{"empty", nil, true},
+		{"header only", full[:6], true},
+		{"bad byte order", append([]byte("XX*\x00"), full[4:]...), true},
+		{"IFD count only", full[:10], true},
+		{"IFD cut short", full[:40], true},
+		{"IFD offset past end", append(append([]byte{}, full[:4]...), 0xff, 0xff, 0, 0), true},
+		// Out of range sub-IFDs and values are skipped, IFD0 stays readable
+		{"values cut off", full[:len(full)-20], false},
+		{"sub-IFDs cut off", full[:8+2+4*12+4+10], false},
+	}
+	for _, tt := range tests {
+		meta := &models.MediaMetadata{}
+		err := parseEXIF(tt.data, meta)
+		if tt.err {
+			if !errors.Is(err, errInvalidEXIF) {
+				t.Errorf("%s: parseEXIF() error = %v, want %v", tt.name, err, errInvalidEXIF)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parseEXIF() error = %v", tt.name, err)
+		}
+		if meta.CameraModel != "EOS" {
+			t.Errorf("%s: camera model = %q, want EOS", tt.name, meta.CameraModel)
+		}
+	}
+}
+
+func TestReadEXIFContainers(t *testing.T) {
+	exif := photoEXIF(binary.LittleEndian)
+
+	segment := func(marker byte, data []byte) []byte {
+		return append([]byte{0xff, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)}, data...)
+	}
+	jpeg := []byte{0xff, 0xd8}
+	jpeg = append(jpeg, segment(0xe0, []byte("JFIF\x00\x01\x02"))...)
+	jpeg = append(jpeg, segment(0xe1, append([]byte("Exif\x00\x00"), exif...))...)
+	jpeg = append(jpeg, 0xff, 0xda)
+
+	chunk := func(typ string, data []byte) []byte {
+		c := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+		c = append(append(c, typ...), data...)
+		return append(c, 0, 0, 0, 0)
+	}
+	png := []byte("\x89PNG\r\n\x1a\n")
+	png = append(png, chunk("IHDR", make([]byte, 13))...)
+	png = append(png, chunk("eXIf", exif)...)
+	png = append(png, chunk("IEND", nil)...)
+
+	riff := func(typ string, data []byte) []byte {
+		c := append([]byte(typ), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+		c = append(c, data...)
+		if len(data)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	webp := []byte("RIFF\x00\x00\x00\x00WEBP")
+	webp = append(webp, riff("VP8X", make([]byte, 9))...)
+	webp = append(webp, riff("EXIF", append([]byte("Exif\x00\x00"), exif...))...)
+
+	for _, tt := range []struct {
+		mimeType string
+		data     []byte
+	}{
+		{"image/jpeg", jpeg},
+		{"image/png", png},
+		{"image/webp", webp},
+	} {
+		meta := &models.MediaMetadata{}
+		if err := readEXIF(bytes.NewReader(tt.data), tt.mimeType, meta); err != nil {
+			t.Fatalf("%s: readEXIF() error = %v", tt.mimeType, err)
+		}
+		checkPhotoEXIF(t, tt.mimeType, meta)
+
+		// Files cut off in the middle of the EXIF block are rejected
+		meta = &models.MediaMetadata{}
+		err := readEXIF(bytes.NewReader(tt.data[:len(tt.data)/2]), tt.mimeType, meta)
+		if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			t.Errorf("%s: readEXIF() of a truncated file error = %v", tt.mimeType, err)
+		}
+	}
+
+	// Images without EXIF have no metadata
+	for _, tt := range []struct {
+		mimeType string
+		data     []byte
+	}{
+		{"image/jpeg", []byte{0xff, 0xd8, 0xff, 0xda, 0, 2}},
+		{"image/png", append([]byte("\x89PNG\r\n\x1a\n"), chunk("IEND", nil)...)},
+		{"image/webp", append([]byte("RIFF\x00\x00\x00\x00WEBP"), riff("VP8 ", make([]byte, 3))...)},
+	} {
+		meta := &models.MediaMetadata{}
+		if err := readEXIF(bytes.NewReader(tt.data), tt.mimeType, meta); err != nil || *meta != (models.MediaMetadata{}) {
+			t.Errorf("%s: readEXIF() without EXIF = %+v, %v", tt.mimeType, meta, err)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func sameFloat(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return math.Abs(*a-*b) < 1e-9
+}
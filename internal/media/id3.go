@@ -0,0 +1,173 @@
+package media
+
+import (
+	"encoding/binary"
+	"github.com/seemsod1/ancy/internal/models"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxID3Size caps the ID3v2 tag read, cover art can make it large
+const maxID3Size = 16 << 20
+
+// id3Frames maps the ID3v2.3 and v2.4 frame IDs, and the shorter v2.2 ones,
+// to the metadata they fill in
+var id3Frames = map[string]func(meta *models.MediaMetadata) *string{
+	"TIT2": func(meta *models.MediaMetadata) *string { return &meta.Title },
+	"TT2":  func(meta *models.MediaMetadata) *string { return &meta.Title },
+	"TPE1": func(meta *models.MediaMetadata) *string { return &meta.Artist },
+	"TP1":  func(meta *models.MediaMetadata) *string { return &meta.Artist },
+	"TALB": func(meta *models.MediaMetadata) *string { return &meta.Album },
+	"TAL":  func(meta *models.MediaMetadata) *string { return &meta.Album },
+	"TYER": func(meta *models.MediaMetadata) *string { return &meta.Year },
+	"TDRC": func(meta *models.MediaMetadata) *string { return &meta.Year },
+	"TYE":  func(meta *models.MediaMetadata) *string { return &meta.Year },
+}
+
+// readID3 fills in the title, artist, album and year from the ID3v2 tag at
+// the start of r, falling back to the ID3v1 tag at its end
+func readID3(r io.ReadSeeker, meta *models.MediaMetadata) error {
+	if err := readID3v2(r, meta); err != nil {
+		return err
+	}
+	if meta.Title != "" && meta.Artist != "" && meta.Album != "" && meta.Year != "" {
+		return nil
+	}
+	return readID3v1(r, meta)
+}
+
+func readID3v2(r io.ReadSeeker, meta *models.MediaMetadata) error {
+	var header [10]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	if string(header[0:3]) != "ID3" {
+		return nil
+	}
+	version, flags := header[3], header[5]
+	size := synchsafe(header[6:10])
+	// Unsynchronised tags would have to be decoded first, they are rare
+	if version < 2 || version > 4 || flags&0x80 != 0 || size > maxID3Size {
+		return nil
+	}
+	tag := make([]byte, size)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return err
+	}
+
+	if flags&0x40 != 0 && version > 2 && len(tag) >= 4 {
+		extended := int(binary.BigEndian.Uint32(tag[0:4])) + 4
+		if version == 4 {
+			extended = synchsafe(tag[0:4])
+		}
+		if extended > len(tag) {
+			return nil
+		}
+		tag = tag[extended:]
+	}
+
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+	for len(tag) >= headerSize && tag[0] != 0 {
+		id := string(tag[:idSize])
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(tag[4:8]))
+		case 4:
+			frameSize = synchsafe(tag[4:8])
+		}
+		if frameSize < 0 || headerSize+frameSize > len(tag) {
+			break
+		}
+		if field, ok := id3Frames[id]; ok {
+			if dest := field(meta); *dest == "" {
+				*dest = id3Text(tag[headerSize : headerSize+frameSize])
+			}
+		}
+		tag = tag[headerSize+frameSize:]
+	}
+	return nil
+}
+
+func readID3v1(r io.ReadSeeker, meta *models.MediaMetadata) error {
+	if _, err := r.Seek(-128, io.SeekEnd); err != nil {
+		// Files shorter than the tag have none
+		return nil
+	}
+	var tag [128]byte
+	if _, err := io.ReadFull(r, tag[:]); err != nil {
+		return err
+	}
+	if string(tag[0:3]) != "TAG" {
+		return nil
+	}
+	for _, f := range []struct {
+		dest  *string
+		value []byte
+	}{
+		{&meta.Title, tag[3:33]},
+		{&meta.Artist, tag[33:63]},
+		{&meta.Album, tag[63:93]},
+		{&meta.Year, tag[93:97]},
+	} {
+		if *f.dest == "" {
+			*f.dest = latin1(f.value)
+		}
+	}
+	return nil
+}
+
+// id3Text decodes a text frame. Frames holding several values keep the first.
+func id3Text(frame []byte) string {
+	if len(frame) < 1 {
+		return ""
+	}
+	encoding, text := frame[0], frame[1:]
+	switch encoding {
+	case 1, 2:
+		order := binary.ByteOrder(binary.BigEndian)
+		if encoding == 1 && len(text) >= 2 {
+			if text[0] == 0xff && text[1] == 0xfe {
+				order = binary.LittleEndian
+			}
+			text = text[2:]
+		}
+		units := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			u := order.Uint16(text[i:])
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		return strings.TrimSpace(string(utf16.Decode(units)))
+	case 3:
+		value, _, _ := strings.Cut(string(text), "\x00")
+		return strings.TrimSpace(strings.ToValidUTF8(value, ""))
+	default:
+		return latin1(text)
+	}
+}
+
+// latin1 decodes NUL terminated ISO-8859-1 text
+func latin1(text []byte) string {
+	runes := make([]rune, 0, len(text))
+	for _, b := range text {
+		if b == 0 {
+			break
+		}
+		runes = append(runes, rune(b))
+	}
+	return strings.TrimSpace(string(runes))
+}
+
+// synchsafe decodes an ID3v2 size, which keeps 7 bits per byte
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
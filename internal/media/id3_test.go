@@ -0,0 +1,167 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/seemsod1/ancy/internal/models"
+	"testing"
+)
+
+// id3v2 builds an ID3v2 tag of version with frames, which are already
+// encoded with their headers
+func id3v2(version, flags byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	size := len(body)
+	tag := []byte{'I', 'D', '3', version, 0, flags,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(tag, body...)
+}
+
+// id3Frame encodes a frame for version
+func id3Frame(version byte, id string, data []byte) []byte {
+	size := len(data)
+	switch version {
+	case 2:
+		return append(append([]byte(id), byte(size>>16), byte(size>>8), byte(size)), data...)
+	case 3:
+		frame := append([]byte(id), binary.BigEndian.AppendUint32(nil, uint32(size))...)
+		return append(append(frame, 0, 0), data...)
+	default:
+		frame := append([]byte(id), byte(size>>21&0x7f), byte(size>>14&0x7f), byte(size>>7&0x7f), byte(size&0x7f))
+		return append(append(frame, 0, 0), data...)
+	}
+}
+
+// id3v1 builds an ID3v1 tag
+func id3v1(title, artist, album, year string) []byte {
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	copy(tag[3:33], title)
+	copy(tag[33:63], artist)
+	copy(tag[63:93], album)
+	copy(tag[93:97], year)
+	return tag
+}
+
+func TestReadID3(t *testing.T) {
+	utf16le := []byte{1, 0xff, 0xfe, 0x1a, 0x04, 0x38, 0x04, 0x47, 0x04, 0x3a, 0x04, 0x30, 0x04, 0, 0}
+	audio := make([]byte, 200)
+
+	tests := []struct {
+		name string
+		file []byte
+		want models.MediaMetadata
+	}{
+		{
+			name: "v2.3",
+			file: append(id3v2(3, 0,
+				id3Frame(3, "TIT2", []byte("\x00Shchedryk")),
+				id3Frame(3, "TPE1", utf16le),
+				id3Frame(3, "TALB", []byte("\x03Колядки\x00")),
+				id3Frame(3, "TYER", []byte("\x001916")),
+				id3Frame(3, "APIC", make([]byte, 300)),
+			), audio...),
+			want: models.MediaMetadata{Title: "Shchedryk", Artist: "Кичка", Album: "Колядки", Year: "1916"},
+		},
+		{
+			name: "v2.4",
+			file: append(id3v2(4, 0,
+				id3Frame(4, "APIC", make([]byte, 200)),
+				id3Frame(4, "TIT2", []byte("\x03Title")),
+				id3Frame(4, "TDRC", []byte("\x032024-05-01")),
+			), audio...),
+			want: models.MediaMetadata{Title: "Title", Year: "2024-05-01"},
+		},
+		{
+			name: "v2.2",
+			file: append(id3v2(2, 0,
+				id3Frame(2, "TT2", []byte("\x00Old")),
+				id3Frame(2, "TP1", []byte("\x00Band")),
+			), audio...),
+			want: models.MediaMetadata{Title: "Old", Artist: "Band"},
+		},
+		{
+			name: "v1 fills the gaps",
+			file: append(append(id3v2(3, 0, id3Frame(3, "TIT2", []byte("\x00New title"))), audio...),
+				id3v1("Old title", "Artist", "Album", "1999")...),
+			want: models.MediaMetadata{Title: "New title", Artist: "Artist", Album: "Album", Year: "1999"},
+		},
+		{
+			name: "v1 only",
+			file: append(audio, id3v1("Title", "Artist", "", "")...),
+			want: models.MediaMetadata{Title: "Title", Artist: "Artist"},
+		},
+		{
+			name: "frame past the tag",
+			file: append(id3v2(3, 0,
+				id3Frame(3, "TIT2", []byte("\x00Kept")),
+				id3Frame(3, "TPE1", []byte("\x00Cut"))[:12],
+			), audio...),
+			want: models.MediaMetadata{Title: "Kept"},
+		},
+		{
+			name: "padding",
+			file: append(id3v2(3, 0, id3Frame(3, "TIT2", []byte("\x00Padded")), make([]byte, 50)), audio...),
+			want: models.MediaMetadata{Title: "Padded"},
+		},
+		{
+			name: "unsynchronised",
+			file: append(id3v2(3, 0x80, id3Frame(3, "TIT2", []byte("\x00Skipped"))), audio...),
+		},
+		{
+			name: "unknown version",
+			file: append(id3v2(5, 0, id3Frame(4, "TIT2", []byte("\x00Skipped"))), audio...),
+		},
+		{
+			name: "extended header past the tag",
+			file: append(id3v2(3, 0x40, []byte{0, 0, 1, 0}, id3Frame(3, "TIT2", []byte("\x00Skipped"))), audio...),
+		},
+		{
+			name: "no tags",
+			file: audio,
+		},
+	}
+	for _, tt := range tests {
+		meta := &models.MediaMetadata{}
+		if err := readID3(bytes.NewReader(tt.file), meta); err != nil {
+			t.Errorf("%s: readID3() error = %v", tt.name, err)
+		}
+		if *meta != tt.want {
+			t.Errorf("%s: readID3() = %+v, want %+v", tt.name, *meta, tt.want)
+		}
+	}
+}
+
+func TestReadID3Truncated(t *testing.T) {
+	tag := id3v2(3, 0, id3Frame(3, "TIT2", []byte("\x00Title")), id3Frame(3, "TPE1", []byte("\x00Artist")))
+	for _, n := range []int{0, 5, 10, len(tag) - 1} {
+		meta := &models.MediaMetadata{}
+		if err := readID3(bytes.NewReader(tag[:n]), meta); err == nil {
+			t.Errorf("readID3() of %d of %d bytes succeeded with %+v", n, len(tag), *meta)
+		}
+	}
+}
+
+func TestID3Text(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		want  string
+	}{
+		{"empty", nil, ""},
+		{"encoding only", []byte{0}, ""},
+		{"latin1", []byte("\x00Caf\xe9 "), "Café"},
+		{"latin1 several values", []byte("\x00One\x00Two"), "One"},
+		{"UTF-16 big endian BOM", []byte{1, 0xfe, 0xff, 0, 'H', 0, 'i'}, "Hi"},
+		{"UTF-16 little endian BOM", []byte{1, 0xff, 0xfe, 'H', 0, 'i', 0}, "Hi"},
+		{"UTF-16BE", []byte{2, 0, 'H', 0, 'i', 0, 0, 0, 'x'}, "Hi"},
+		{"UTF-16 odd length", []byte{2, 0, 'H', 0}, "H"},
+		{"UTF-8", []byte("\x03Київ\x00Lviv"), "Київ"},
+		{"invalid UTF-8", []byte("\x03ok\xff"), "ok"},
+	}
+	for _, tt := range tests {
+		if got := id3Text(tt.frame); got != tt.want {
+			t.Errorf("%s: id3Text() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
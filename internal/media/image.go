@@ -0,0 +1,111 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// maxPixels guards the decoder against decompression bombs
+const maxPixels = 50_000_000
+
+// ErrImageTooLarge is returned for images with more than maxPixels pixels
+var ErrImageTooLarge = errors.New("media: image is too large")
+
+// Rendition is a resized copy of an image that fits in a MaxSize square
+type Rendition struct {
+	Name    string
+	MaxSize int
+}
+
+// Renditions are generated for every exhibit image, smallest first
+var Renditions = []Rendition{
+	{Name: "thumbnail", MaxSize: 320},
+	{Name: "card", MaxSize: 640},
+	{Name: "full", MaxSize: 1920},
+}
+
+// Rendered is an encoded rendition
+type Rendered struct {
+	Name string
+	Ext  string
+	Data []byte
+}
+
+// DecodeImage decodes a JPEG, PNG, GIF or WebP image from r
+func DecodeImage(r io.ReadSeeker) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrImageTooLarge
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// Resize scales img down to fit in a size x size square keeping the aspect
+// ratio. Images that already fit are returned unchanged.
+func Resize(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// Encode writes img as JPEG, or as PNG when it has transparent pixels, and
+// returns the extension of the chosen format
+func Encode(w io.Writer, img image.Image) (string, error) {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+		return ".png", png.Encode(w, img)
+	}
+	return ".jpg", jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+}
+
+// RenderAll decodes the image in r and encodes every rendition of it
+func RenderAll(r io.ReadSeeker) ([]Rendered, error) {
+	img, err := DecodeImage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := make([]Rendered, 0, len(Renditions))
+	for _, rendition := range Renditions {
+		var buf bytes.Buffer
+		ext, err := Encode(&buf, Resize(img, rendition.MaxSize))
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, Rendered{
+			Name: rendition.Name,
+			Ext:  ext,
+			Data: buf.Bytes(),
+		})
+	}
+
+	return rendered, nil
+}
@@ -0,0 +1,102 @@
+package media
+
+import (
+	"github.com/seemsod1/ancy/internal/models"
+	"image"
+	"io"
+)
+
+// ExtractMetadata reads the metadata of an asset of mimeType: EXIF of JPEG,
+// PNG and WebP photos, ID3 tags of MP3s, the format of WAVs and the movie
+// header of MP4 and QuickTime videos. Metadata is best effort, whatever
+// can't be decoded is left out. It returns nil when nothing was found and
+// rewinds r.
+func ExtractMetadata(r io.ReadSeeker, mimeType string) (*models.MediaMetadata, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &models.MediaMetadata{}
+	for _, read := range metadataReaders(mimeType) {
+		if _, err = r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		// Malformed metadata is skipped, the asset itself may still be fine
+		_ = read(r, size, meta)
+	}
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if *meta == (models.MediaMetadata{}) {
+		return nil, nil
+	}
+	return meta, nil
+}
+
+// metadataReader fills in meta from r, which holds size bytes
+type metadataReader func(r io.ReadSeeker, size int64, meta *models.MediaMetadata) error
+
+// metadataReaders returns the readers that apply to mimeType
+func metadataReaders(mimeType string) []metadataReader {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/webp", "image/gif":
+		return []metadataReader{readDimensions, func(r io.ReadSeeker, _ int64, meta *models.MediaMetadata) error {
+			return readEXIF(r, mimeType, meta)
+		}}
+	case "audio/mpeg":
+		return []metadataReader{readMP3, func(r io.ReadSeeker, _ int64, meta *models.MediaMetadata) error {
+			return readID3(r, meta)
+		}}
+	case "audio/wav":
+		return []metadataReader{readWAV}
+	case "video/mp4", "video/quicktime":
+		return []metadataReader{readMP4, withBitrate}
+	}
+	return nil
+}
+
+// readDimensions reads the size of an image from its header
+func readDimensions(r io.ReadSeeker, _ int64, meta *models.MediaMetadata) error {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	meta.Width, meta.Height = cfg.Width, cfg.Height
+	return nil
+}
+
+// readMP3 reads the duration of an MP3, go-mp3 finds it by scanning the frames
+func readMP3(r io.ReadSeeker, size int64, meta *models.MediaMetadata) error {
+	dec, err := newMP3Decoder(r)
+	if err != nil {
+		return err
+	}
+	if rate := dec.dec.SampleRate(); rate > 0 {
+		meta.Duration = float64(dec.Frames()) / float64(rate)
+	}
+	return withBitrate(r, size, meta)
+}
+
+// readWAV reads the duration and bitrate of a WAV from its format chunk
+func readWAV(r io.ReadSeeker, _ int64, meta *models.MediaMetadata) error {
+	dec, err := newWAVDecoder(r)
+	if err != nil {
+		return err
+	}
+	if dec.rate > 0 {
+		meta.Duration = float64(dec.frames) / float64(dec.rate)
+		meta.Bitrate = dec.rate * dec.channels * dec.bits
+	}
+	return nil
+}
+
+// withBitrate sets the average bitrate of compressed media from the file
+// size once the duration is known
+func withBitrate(_ io.ReadSeeker, size int64, meta *models.MediaMetadata) error {
+	if meta.Duration > 0 && meta.Bitrate == 0 {
+		meta.Bitrate = int(float64(size*8) / meta.Duration)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package media
+
+import (
+	"fmt"
+	"github.com/gabriel-vasile/mimetype"
+	"io"
+	"strings"
+)
+
+// PreviewTypes are the MIME types accepted for preview images
+var PreviewTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// IsAllowed reports whether mimeType is one of allowed. A nil allow-list
+// accepts any type.
+func IsAllowed(mimeType string, allowed []string) bool {
+	if allowed == nil {
+		return true
+	}
+	for _, a := range allowed {
+		if a == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// UnsupportedTypeError is returned when the sniffed content type of an
+// upload is not in the allow-list. It is sent to the client as is.
+type UnsupportedTypeError struct {
+	Field    string   `json:"field"`
+	Detected string   `json:"detected"`
+	Allowed  []string `json:"allowed"`
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("%s: content type %s is not one of %s", e.Field, e.Detected, strings.Join(e.Allowed, ", "))
+}
+
+// Sniff detects the content type of r from its leading bytes and rewinds r
+func Sniff(r io.ReadSeeker) (*mimetype.MIME, error) {
+	mtype, err := mimetype.DetectReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return mtype, nil
+}
+
+// Check sniffs the content of the form field and makes sure it is one of allowed
+func Check(field string, r io.ReadSeeker, allowed []string) (*mimetype.MIME, error) {
+	mtype, err := Sniff(r)
+	if err != nil {
+		return nil, err
+	}
+	if allowed == nil {
+		return mtype, nil
+	}
+	for _, a := range allowed {
+		if mtype.Is(a) {
+			return mtype, nil
+		}
+	}
+
+	detected, _, _ := strings.Cut(mtype.String(), ";")
+	return nil, &UnsupportedTypeError{
+		Field:    field,
+		Detected: detected,
+		Allowed:  allowed,
+	}
+}
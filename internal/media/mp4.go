@@ -0,0 +1,182 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/seemsod1/ancy/internal/models"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// maxBoxRead caps the size of the leaf boxes read into memory
+const maxBoxRead = 1 << 16
+
+var errInvalidMP4 = errors.New("media: invalid MP4 data")
+
+// mp4Epoch is where MP4 and QuickTime times start
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// iso6709 matches the location QuickTime keeps in the ©xyz box, such as
+// +50.4501+030.5234+179.000/
+var iso6709 = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)`)
+
+// readMP4 fills in the duration, creation time, dimensions of the first
+// video track and the location of an MP4 or QuickTime file
+func readMP4(r io.ReadSeeker, size int64, meta *models.MediaMetadata) error {
+	return walkBoxes(r, 0, size, func(typ string, start, end int64) error {
+		if typ != "moov" {
+			return nil
+		}
+		return walkBoxes(r, start, end, func(typ string, start, end int64) error {
+			switch typ {
+			case "mvhd":
+				return readMVHD(r, start, end, meta)
+			case "trak":
+				return walkBoxes(r, start, end, func(typ string, start, end int64) error {
+					if typ == "tkhd" {
+						return readTKHD(r, start, end, meta)
+					}
+					return nil
+				})
+			case "udta":
+				return walkBoxes(r, start, end, func(typ string, start, end int64) error {
+					if typ == "\xa9xyz" {
+						return readLocation(r, start, end, meta)
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+}
+
+// walkBoxes calls fn with the type and content range of every box between
+// start and end
+func walkBoxes(r io.ReadSeeker, start, end int64, fn func(typ string, start, end int64) error) error {
+	for offset := start; offset+8 <= end; {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		var header [16]byte
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header[0:4])), int64(8)
+		switch size {
+		case 0:
+			// The last box runs to the end of the file
+			size = end - offset
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if size < headerSize || offset+size > end {
+			return errInvalidMP4
+		}
+		if err := fn(string(header[4:8]), offset+headerSize, offset+size); err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
+
+// readBox reads the content of a leaf box
+func readBox(r io.ReadSeeker, start, end int64) ([]byte, error) {
+	if end-start > maxBoxRead {
+		return nil, errInvalidMP4
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, end-start)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// readMVHD reads the creation time and duration of the movie
+func readMVHD(r io.ReadSeeker, start, end int64, meta *models.MediaMetadata) error {
+	data, err := readBox(r, start, end)
+	if err != nil {
+		return err
+	}
+	var created, timescale, duration uint64
+	switch {
+	case len(data) >= 20 && data[0] == 0:
+		created = uint64(binary.BigEndian.Uint32(data[4:8]))
+		timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	case len(data) >= 32 && data[0] == 1:
+		created = binary.BigEndian.Uint64(data[4:12])
+		timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+		duration = binary.BigEndian.Uint64(data[24:32])
+	default:
+		return errInvalidMP4
+	}
+
+	if timescale > 0 {
+		meta.Duration = float64(duration) / float64(timescale)
+	}
+	// Unset times are 0, some encoders write the Unix epoch instead
+	if created > 0 && created < 1<<33 {
+		captured := mp4Epoch.Add(time.Duration(created) * time.Second)
+		if captured.Year() > 1970 {
+			meta.CapturedAt = &captured
+		}
+	}
+	return nil
+}
+
+// readTKHD reads the dimensions of the first track that has any, audio
+// tracks have none
+func readTKHD(r io.ReadSeeker, start, end int64, meta *models.MediaMetadata) error {
+	if meta.Width > 0 {
+		return nil
+	}
+	data, err := readBox(r, start, end)
+	if err != nil {
+		return err
+	}
+	offset := 76
+	if len(data) > 0 && data[0] == 1 {
+		offset = 88
+	}
+	if len(data) < offset+8 {
+		return errInvalidMP4
+	}
+	// The dimensions are 16.16 fixed point numbers
+	meta.Width = int(binary.BigEndian.Uint32(data[offset:]) >> 16)
+	meta.Height = int(binary.BigEndian.Uint32(data[offset+4:]) >> 16)
+	return nil
+}
+
+// readLocation reads the ISO 6709 location of a ©xyz box
+func readLocation(r io.ReadSeeker, start, end int64, meta *models.MediaMetadata) error {
+	data, err := readBox(r, start, end)
+	if err != nil {
+		return err
+	}
+	// The text is preceded by its length and language
+	if len(data) < 4 {
+		return errInvalidMP4
+	}
+	match := iso6709.FindStringSubmatch(string(data[4:]))
+	if match == nil {
+		return nil
+	}
+	lat, err := strconv.ParseFloat(match[1], 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return nil
+	}
+	lon, err := strconv.ParseFloat(match[2], 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return nil
+	}
+	meta.Latitude, meta.Longitude = &lat, &lon
+	return nil
+}
@@ -0,0 +1,194 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/seemsod1/ancy/internal/models"
+	"io"
+	"testing"
+	"time"
+)
+
+// box encodes an MP4 box of typ holding content
+func box(typ string, content ...[]byte) []byte {
+	data := bytes.Join(content, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(b, typ...), data...)
+}
+
+// mvhd encodes a version 0 movie header
+func mvhd(created time.Time, timescale, duration uint32) []byte {
+	var data []byte
+	if !created.IsZero() {
+		data = binary.BigEndian.AppendUint32(make([]byte, 4), uint32(created.Sub(mp4Epoch)/time.Second))
+	} else {
+		data = make([]byte, 8)
+	}
+	data = append(data, 0, 0, 0, 0)
+	data = binary.BigEndian.AppendUint32(data, timescale)
+	data = binary.BigEndian.AppendUint32(data, duration)
+	return box("mvhd", data, make([]byte, 80))
+}
+
+// tkhd encodes a version 0 track header
+func tkhd(width, height uint32) []byte {
+	data := make([]byte, 76)
+	data = binary.BigEndian.AppendUint32(data, width<<16)
+	data = binary.BigEndian.AppendUint32(data, height<<16)
+	return box("tkhd", data)
+}
+
+func xyz(location string) []byte {
+	data := binary.BigEndian.AppendUint16(nil, uint16(len(location)))
+	return box("\xa9xyz", data, []byte{0x15, 0xc7}, []byte(location))
+}
+
+func TestReadMP4(t *testing.T) {
+	created := time.Date(2021, 6, 15, 11, 30, 0, 0, time.UTC)
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))
+	mdat := box("mdat", make([]byte, 1000))
+
+	v1 := make([]byte, 4)
+	v1[0] = 1
+	v1 = binary.BigEndian.AppendUint64(v1, uint64(created.Sub(mp4Epoch)/time.Second))
+	v1 = binary.BigEndian.AppendUint64(v1, 0)
+	v1 = binary.BigEndian.AppendUint32(v1, 1000)
+	v1 = binary.BigEndian.AppendUint64(v1, 90500)
+
+	largeMdat := append(binary.BigEndian.AppendUint32(nil, 1), "mdat"...)
+	largeMdat = binary.BigEndian.AppendUint64(largeMdat, 16+10)
+	largeMdat = append(largeMdat, make([]byte, 10)...)
+
+	tests := []struct {
+		name string
+		file []byte
+		want models.MediaMetadata
+	}{
+		{
+			name: "video",
+			file: bytes.Join([][]byte{ftyp, box("moov",
+				mvhd(created, 600, 54300),
+				box("trak", tkhd(0, 0)),
+				box("trak", tkhd(1920, 1080)),
+				box("trak", tkhd(640, 480)),
+				box("udta", xyz("+50.4501+030.5234+179.000/")),
+			), mdat}, nil),
+			want: models.MediaMetadata{Duration: 90.5, Width: 1920, Height: 1080, CapturedAt: &created, Latitude: ptr(50.4501), Longitude: ptr(30.5234)},
+		},
+		{
+			name: "version 1 header",
+			file: bytes.Join([][]byte{ftyp, box("moov", box("mvhd", v1))}, nil),
+			want: models.MediaMetadata{Duration: 90.5, CapturedAt: &created},
+		},
+		{
+			name: "moov after mdat",
+			file: bytes.Join([][]byte{ftyp, mdat, box("moov", mvhd(time.Time{}, 1000, 2000))}, nil),
+			want: models.MediaMetadata{Duration: 2},
+		},
+		{
+			name: "64 bit size",
+			file: bytes.Join([][]byte{ftyp, largeMdat, box("moov", mvhd(time.Time{}, 1000, 2000))}, nil),
+			want: models.MediaMetadata{Duration: 2},
+		},
+		{
+			name: "last box to the end",
+			file: bytes.Join([][]byte{ftyp, append([]byte{0, 0, 0, 0}, box("moov", mvhd(time.Time{}, 1000, 2000))[4:]...)}, nil),
+			want: models.MediaMetadata{Duration: 2},
+		},
+		{
+			name: "Unix epoch creation time",
+			file: box("moov", mvhd(time.Unix(0, 0).UTC(), 1000, 2000)),
+			want: models.MediaMetadata{Duration: 2},
+		},
+		{
+			name: "no timescale",
+			file: box("moov", mvhd(time.Time{}, 0, 2000)),
+		},
+		{
+			name: "invalid location",
+			file: box("moov", box("udta", xyz("+95.0000+030.5234/"), xyz("somewhere"))),
+		},
+		{
+			name: "no moov",
+			file: bytes.Join([][]byte{ftyp, mdat}, nil),
+		},
+	}
+	for _, tt := range tests {
+		meta := &models.MediaMetadata{}
+		if err := readMP4(bytes.NewReader(tt.file), int64(len(tt.file)), meta); err != nil {
+			t.Errorf("%s: readMP4() error = %v", tt.name, err)
+		}
+		checkMP4(t, tt.name, meta, tt.want)
+	}
+}
+
+func checkMP4(t *testing.T, name string, got *models.MediaMetadata, want models.MediaMetadata) {
+	t.Helper()
+	if got.Duration != want.Duration || got.Width != want.Width || got.Height != want.Height {
+		t.Errorf("%s: duration %v and size %dx%d, want %v and %dx%d", name, got.Duration, got.Width, got.Height, want.Duration, want.Width, want.Height)
+	}
+	if (got.CapturedAt == nil) != (want.CapturedAt == nil) || want.CapturedAt != nil && !got.CapturedAt.Equal(*want.CapturedAt) {
+		t.Errorf("%s: captured at %v, want %v", name, got.CapturedAt, want.CapturedAt)
+	}
+	if !sameFloat(got.Latitude, want.Latitude) || !sameFloat(got.Longitude, want.Longitude) {
+		t.Errorf("%s: location %v, %v, want %v, %v", name, got.Latitude, got.Longitude, want.Latitude, want.Longitude)
+	}
+}
+
+func TestReadMP4Truncated(t *testing.T) {
+	moov := box("moov", mvhd(time.Time{}, 1000, 2000), box("trak", tkhd(1920, 1080)))
+
+	oversized := box("moov", box("udta", make([]byte, maxBoxRead+1)))
+	oversized = append(oversized[:16], "\xa9xyz"...)
+	oversized = append(oversized, make([]byte, maxBoxRead+1-4)...)
+
+	tests := []struct {
+		name string
+		file []byte
+		want error
+	}{
+		{"moov cut short", moov[:len(moov)-10], errInvalidMP4},
+		{"size smaller than header", append(box("moov", []byte{0, 0, 0, 4, 'm', 'v', 'h', 'd'}), make([]byte, 8)...), errInvalidMP4},
+		{"64 bit size cut short", append([]byte{0, 0, 0, 1}, "moov\x00\x00"...), io.ErrUnexpectedEOF},
+		{"mvhd too short", box("moov", box("mvhd", make([]byte, 12))), errInvalidMP4},
+		{"mvhd unknown version", box("moov", box("mvhd", append([]byte{2}, make([]byte, 40)...))), errInvalidMP4},
+		{"tkhd too short", box("moov", box("trak", box("tkhd", make([]byte, 80)))), errInvalidMP4},
+		{"location too short", box("moov", box("udta", box("\xa9xyz", []byte{0}))), errInvalidMP4},
+		{"leaf box too large", oversized, errInvalidMP4},
+	}
+	for _, tt := range tests {
+		meta := &models.MediaMetadata{}
+		if err := readMP4(bytes.NewReader(tt.file), int64(len(tt.file)), meta); !errors.Is(err, tt.want) {
+			t.Errorf("%s: readMP4() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Trailing bytes too short for a box header are ignored
+	file := append(moov, 0, 0, 0, 20, 'm', 'v')
+	meta := &models.MediaMetadata{}
+	if err := readMP4(bytes.NewReader(file), int64(len(file)), meta); err != nil || meta.Width != 1920 {
+		t.Errorf("readMP4() with trailing bytes = %+v, %v", *meta, err)
+	}
+}
+
+func TestExtractMetadataMP4(t *testing.T) {
+	file := bytes.Join([][]byte{box("moov", mvhd(time.Time{}, 1000, 2000)), box("mdat", make([]byte, 1000))}, nil)
+	r := bytes.NewReader(file)
+	meta, err := ExtractMetadata(r, "video/mp4")
+	if err != nil {
+		t.Fatalf("ExtractMetadata() error = %v", err)
+	}
+	if meta == nil || meta.Duration != 2 || meta.Bitrate != len(file)*8/2 {
+		t.Errorf("ExtractMetadata() = %+v, want 2 seconds at %d bits per second", meta, len(file)*8/2)
+	}
+	if pos, _ := r.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("ExtractMetadata() left the reader at %d", pos)
+	}
+
+	// Broken metadata is skipped rather than failing the upload
+	meta, err = ExtractMetadata(bytes.NewReader(file[:20]), "video/mp4")
+	if err != nil || meta != nil {
+		t.Errorf("ExtractMetadata() of a truncated file = %+v, %v, want nothing", meta, err)
+	}
+}
@@ -0,0 +1,248 @@
+package media
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/hajimehoshi/go-mp3"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+const (
+	waveformWidth  = 1200
+	waveformHeight = 300
+)
+
+var (
+	waveformBackground = color.RGBA{R: 0x21, G: 0x25, B: 0x29, A: 0xff}
+	waveformForeground = color.RGBA{R: 0x0d, G: 0x6e, B: 0xfd, A: 0xff}
+)
+
+// ErrUnsupportedAudio is returned for audio formats that can't be decoded
+var ErrUnsupportedAudio = errors.New("media: unsupported audio format")
+
+// audioDecoder yields the samples of an audio stream mixed down to mono
+type audioDecoder interface {
+	// Frames returns the total number of frames in the stream
+	Frames() int64
+	// Next returns the absolute amplitude of the next frame in [0, 1]
+	Next() (float64, error)
+}
+
+// CanRenderWaveform reports whether a waveform can be generated for mimeType
+func CanRenderWaveform(mimeType string) bool {
+	return mimeType == "audio/wav" || mimeType == "audio/mpeg"
+}
+
+// Waveform decodes a WAV or MP3 stream and draws its peak amplitudes
+func Waveform(r io.ReadSeeker, mimeType string) (image.Image, error) {
+	var dec audioDecoder
+	var err error
+	switch mimeType {
+	case "audio/wav":
+		dec, err = newWAVDecoder(r)
+	case "audio/mpeg":
+		dec, err = newMP3Decoder(r)
+	default:
+		return nil, ErrUnsupportedAudio
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	peaks, err := readPeaks(dec, waveformWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	return drawWaveform(peaks, waveformHeight), nil
+}
+
+// readPeaks splits the stream into columns buckets and returns the peak of each
+func readPeaks(dec audioDecoder, columns int) ([]float64, error) {
+	total := dec.Frames()
+	if total <= 0 {
+		return nil, ErrUnsupportedAudio
+	}
+	perColumn := (total + int64(columns) - 1) / int64(columns)
+
+	peaks := make([]float64, columns)
+	for i := int64(0); i < total; i++ {
+		v, err := dec.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		col := int(i / perColumn)
+		if col < columns && v > peaks[col] {
+			peaks[col] = v
+		}
+	}
+
+	return peaks, nil
+}
+
+// drawWaveform draws peaks as bars mirrored around the horizontal center
+func drawWaveform(peaks []float64, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, len(peaks), height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: waveformBackground}, image.Point{}, draw.Src)
+
+	mid := height / 2
+	for x, peak := range peaks {
+		h := int(peak * float64(mid-1))
+		for y := mid - h; y <= mid+h; y++ {
+			img.SetRGBA(x, y, waveformForeground)
+		}
+	}
+
+	return img
+}
+
+// wavDecoder reads PCM and IEEE float WAV files
+type wavDecoder struct {
+	r        *bufio.Reader
+	frames   int64
+	channels int
+	rate     int
+	bits     int
+	float    bool
+	buf      []byte
+}
+
+func newWAVDecoder(r io.Reader) (*wavDecoder, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, ErrUnsupportedAudio
+	}
+
+	d := &wavDecoder{}
+	br := bufio.NewReader(r)
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(br, chunk[:]); err != nil {
+			return nil, ErrUnsupportedAudio
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > 1<<16 {
+				return nil, ErrUnsupportedAudio
+			}
+			fmtChunk := make([]byte, size)
+			if _, err := io.ReadFull(br, fmtChunk); err != nil {
+				return nil, err
+			}
+			format := binary.LittleEndian.Uint16(fmtChunk[0:2])
+			d.channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			d.rate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			d.bits = int(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+			// WAVE_FORMAT_EXTENSIBLE keeps the real format in the sub format GUID
+			if format == 0xfffe && size >= 26 {
+				format = binary.LittleEndian.Uint16(fmtChunk[24:26])
+			}
+			switch {
+			case format == 1 && (d.bits == 8 || d.bits == 16 || d.bits == 24 || d.bits == 32):
+			case format == 3 && d.bits == 32:
+				d.float = true
+			default:
+				return nil, ErrUnsupportedAudio
+			}
+			if d.channels == 0 {
+				return nil, ErrUnsupportedAudio
+			}
+			// Chunks are word aligned
+			if size%2 == 1 {
+				if _, err := br.Discard(1); err != nil {
+					return nil, err
+				}
+			}
+		case "data":
+			if d.channels == 0 {
+				return nil, ErrUnsupportedAudio
+			}
+			frameSize := int64(d.channels * d.bits / 8)
+			d.frames = size / frameSize
+			d.buf = make([]byte, frameSize)
+			d.r = br
+			return d, nil
+		default:
+			if _, err := br.Discard(int(size + size%2)); err != nil {
+				return nil, ErrUnsupportedAudio
+			}
+		}
+	}
+}
+
+func (d *wavDecoder) Frames() int64 {
+	return d.frames
+}
+
+func (d *wavDecoder) Next() (float64, error) {
+	if _, err := io.ReadFull(d.r, d.buf); err != nil {
+		return 0, err
+	}
+
+	var sum float64
+	width := d.bits / 8
+	for c := 0; c < d.channels; c++ {
+		s := d.buf[c*width : (c+1)*width]
+		var v float64
+		switch {
+		case d.float:
+			v = float64(math.Float32frombits(binary.LittleEndian.Uint32(s)))
+		case d.bits == 8:
+			v = (float64(s[0]) - 128) / 128
+		case d.bits == 16:
+			v = float64(int16(binary.LittleEndian.Uint16(s))) / (1 << 15)
+		case d.bits == 24:
+			v = float64(int32(uint32(s[0])<<8|uint32(s[1])<<16|uint32(s[2])<<24)>>8) / (1 << 23)
+		case d.bits == 32:
+			v = float64(int32(binary.LittleEndian.Uint32(s))) / (1 << 31)
+		}
+		sum += math.Abs(v)
+	}
+
+	return math.Min(sum/float64(d.channels), 1), nil
+}
+
+// mp3Decoder wraps go-mp3, which always produces 16 bit stereo samples
+type mp3Decoder struct {
+	dec *mp3.Decoder
+	r   *bufio.Reader
+	buf [4]byte
+}
+
+func newMP3Decoder(r io.ReadSeeker) (*mp3Decoder, error) {
+	dec, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	return &mp3Decoder{
+		dec: dec,
+		r:   bufio.NewReaderSize(dec, 64*1024),
+	}, nil
+}
+
+func (d *mp3Decoder) Frames() int64 {
+	return d.dec.Length() / 4
+}
+
+func (d *mp3Decoder) Next() (float64, error) {
+	if _, err := io.ReadFull(d.r, d.buf[:]); err != nil {
+		return 0, err
+	}
+	left := float64(int16(binary.LittleEndian.Uint16(d.buf[0:2]))) / (1 << 15)
+	right := float64(int16(binary.LittleEndian.Uint16(d.buf[2:4]))) / (1 << 15)
+	return (math.Abs(left) + math.Abs(right)) / 2, nil
+}
@@ -0,0 +1,186 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+// wav builds a WAV file with a fmt chunk of format, channels and bits and a
+// data chunk declaring dataSize bytes, of which data is present
+func wav(format, channels, bits int, dataSize uint32, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+len(data)))
+	b.WriteString("WAVE")
+	// An unknown chunk before the format is skipped
+	b.WriteString("LIST")
+	binary.Write(&b, binary.LittleEndian, uint32(3))
+	b.WriteString("abc\x00")
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(format))
+	binary.Write(&b, binary.LittleEndian, uint16(channels))
+	binary.Write(&b, binary.LittleEndian, uint32(8000))
+	binary.Write(&b, binary.LittleEndian, uint32(8000*channels*bits/8))
+	binary.Write(&b, binary.LittleEndian, uint16(channels*bits/8))
+	binary.Write(&b, binary.LittleEndian, uint16(bits))
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, dataSize)
+	b.Write(data)
+	return b.Bytes()
+}
+
+func samples16(values ...int16) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, values)
+	return b.Bytes()
+}
+
+func TestWAVDecoder(t *testing.T) {
+	float := func(values ...float32) []byte {
+		var b bytes.Buffer
+		binary.Write(&b, binary.LittleEndian, values)
+		return b.Bytes()
+	}
+	tests := []struct {
+		name string
+		file []byte
+		want []float64
+	}{
+		{"8 bit", wav(1, 1, 8, 3, []byte{128, 192, 0}), []float64{0, 0.5, 1}},
+		{"16 bit", wav(1, 1, 16, 6, samples16(0, 16384, -32768)), []float64{0, 0.5, 1}},
+		{"16 bit stereo", wav(1, 2, 16, 8, samples16(16384, -16384, 32767, 0)), []float64{0.5, 32767.0 / 65536}},
+		{"24 bit", wav(1, 1, 24, 6, []byte{0, 0, 0x40, 0, 0, 0x80}), []float64{0.5, 1}},
+		{"32 bit", wav(1, 1, 32, 4, []byte{0, 0, 0, 0xc0}), []float64{0.5}},
+		{"float", wav(3, 1, 32, 8, float(-0.25, 2)), []float64{0.25, 1}},
+	}
+	for _, tt := range tests {
+		dec, err := newWAVDecoder(bytes.NewReader(tt.file))
+		if err != nil {
+			t.Fatalf("%s: newWAVDecoder() error = %v", tt.name, err)
+		}
+		if dec.Frames() != int64(len(tt.want)) {
+			t.Errorf("%s: Frames() = %d, want %d", tt.name, dec.Frames(), len(tt.want))
+		}
+		for i, want := range tt.want {
+			got, err := dec.Next()
+			if err != nil {
+				t.Fatalf("%s: Next() error = %v", tt.name, err)
+			}
+			if math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s: frame %d = %v, want %v", tt.name, i, got, want)
+			}
+		}
+		if _, err = dec.Next(); err != io.EOF {
+			t.Errorf("%s: Next() past the end error = %v, want %v", tt.name, err, io.EOF)
+		}
+	}
+}
+
+func TestWAVDecoderInvalid(t *testing.T) {
+	valid := wav(1, 1, 16, 2, samples16(1))
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"empty", nil},
+		{"not RIFF", append([]byte("RIFX"), valid[4:]...)},
+		{"not WAVE", append(append([]byte{}, valid[:8]...), append([]byte("AVI "), valid[12:]...)...)},
+		{"compressed", wav(2, 1, 16, 2, samples16(1))},
+		{"12 bit", wav(1, 1, 12, 2, samples16(1))},
+		{"float 64", wav(3, 1, 64, 8, make([]byte, 8))},
+		{"no channels", wav(1, 0, 16, 2, samples16(1))},
+		{"truncated header", valid[:30]},
+		{"no data chunk", valid[:48]},
+	}
+	for _, tt := range tests {
+		if _, err := newWAVDecoder(bytes.NewReader(tt.file)); err == nil {
+			t.Errorf("%s: newWAVDecoder() succeeded", tt.name)
+		}
+	}
+}
+
+func TestWaveform(t *testing.T) {
+	// A declared size beyond the data present ends at the truncation
+	data := samples16(32767, -32768, 0, 16384)
+	file := wav(1, 1, 16, 1<<20, data)
+
+	img, err := Waveform(bytes.NewReader(file), "audio/wav")
+	if err != nil {
+		t.Fatalf("Waveform() error = %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != waveformWidth || b.Dy() != waveformHeight {
+		t.Errorf("Waveform() size = %v, want %dx%d", b, waveformWidth, waveformHeight)
+	}
+	mid := waveformHeight / 2
+	if c := img.At(0, mid); c != waveformForeground {
+		t.Errorf("center of the first column = %v, want %v", c, waveformForeground)
+	}
+	if c := img.At(0, 0); c != waveformBackground {
+		t.Errorf("top of the first column = %v, want %v", c, waveformBackground)
+	}
+
+	for _, mimeType := range []string{"audio/ogg", "image/png"} {
+		if _, err = Waveform(bytes.NewReader(file), mimeType); !errors.Is(err, ErrUnsupportedAudio) {
+			t.Errorf("Waveform(%s) error = %v, want %v", mimeType, err, ErrUnsupportedAudio)
+		}
+	}
+	empty := wav(1, 1, 16, 0, nil)
+	if _, err = Waveform(bytes.NewReader(empty), "audio/wav"); !errors.Is(err, ErrUnsupportedAudio) {
+		t.Errorf("Waveform() of silence without frames error = %v, want %v", err, ErrUnsupportedAudio)
+	}
+}
+
+// fixedDecoder plays back peaks
+type fixedDecoder struct {
+	frames []float64
+	total  int64
+}
+
+func (d *fixedDecoder) Frames() int64 {
+	return d.total
+}
+
+func (d *fixedDecoder) Next() (float64, error) {
+	if len(d.frames) == 0 {
+		return 0, io.EOF
+	}
+	v := d.frames[0]
+	d.frames = d.frames[1:]
+	return v, nil
+}
+
+func TestReadPeaks(t *testing.T) {
+	tests := []struct {
+		name    string
+		frames  []float64
+		total   int64
+		columns int
+		want    []float64
+	}{
+		{"one per column", []float64{0.1, 0.2, 0.3}, 3, 3, []float64{0.1, 0.2, 0.3}},
+		{"peak of each column", []float64{0.1, 0.5, 0.4, 0.2}, 4, 2, []float64{0.5, 0.4}},
+		{"uneven", []float64{0.1, 0.5, 0.4, 0.2, 0.9}, 5, 2, []float64{0.5, 0.9}},
+		{"fewer frames than columns", []float64{0.3, 0.6}, 2, 4, []float64{0.3, 0.6, 0, 0}},
+		{"truncated", []float64{0.3}, 4, 2, []float64{0.3, 0}},
+	}
+	for _, tt := range tests {
+		got, err := readPeaks(&fixedDecoder{frames: tt.frames, total: tt.total}, tt.columns)
+		if err != nil {
+			t.Fatalf("%s: readPeaks() error = %v", tt.name, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: readPeaks() = %v, want %v", tt.name, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: readPeaks() = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Asset tracks a content addressed file in storage and how many
+// Exhibit and User rows reference it
+type Asset struct {
+	Key       string `gorm:"primaryKey;size:255"`
+	Hash      string `gorm:"size:64;not null;index"`
+	Size      int64
+	RefCount  int `gorm:"not null;default:0"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
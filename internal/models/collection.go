@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Collection is a curated, ordered group of exhibits. Private collections
+// are only visible to their owner and admins.
+type Collection struct {
+	ID          int    `gorm:"primaryKey"`
+	Title       string `gorm:"size:255;not null"`
+	Description string `gorm:"size:1024"`
+	// CoverExhibitID picks the exhibit whose preview is the cover, it has to
+	// be one of the items
+	CoverExhibitID *int
+	CoverExhibit   *Exhibit         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:",omitempty"`
+	OwnerID        int              `gorm:"not null;index"`
+	Owner          User             `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Public         bool             `gorm:"not null;default:false;index"`
+	Items          []CollectionItem `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:",omitempty"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// CollectionItem places an exhibit at Position in a collection
+type CollectionItem struct {
+	CollectionID int     `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	ExhibitID    int     `gorm:"primaryKey;autoIncrement:false"`
+	Exhibit      Exhibit `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Position     int     `gorm:"not null"`
+}
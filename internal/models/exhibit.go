@@ -1,26 +1,138 @@
 package models
 
-import "time"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type Exhibit struct {
-	ID          int    `gorm:"primaryKey"`
-	Title       string `gorm:"size:255;not null"`
-	TypeID      int
-	Type        ExhibitType `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
-	Description string      `gorm:"size:255"`
-	AssetPath   string      `gorm:"size:255;not null"`
-	PreviewPath string      `gorm:"size:255;not null"`
-	StatusID    int
-	Status      ExhibitStatus `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
-	AuthorID    int
-	Author      User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID            int    `gorm:"primaryKey"`
+	Title         string `gorm:"size:255;not null"`
+	TypeID        int
+	Type          ExhibitType `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	Description   string      `gorm:"size:255"`
+	AssetPath     string      `gorm:"size:255;not null"`
+	PreviewPath   string      `gorm:"size:255;not null"`
+	MimeType      string      `gorm:"size:255"`
+	ThumbnailPath string      `gorm:"size:255"`
+	CardPath      string      `gorm:"size:255"`
+	FullPath      string      `gorm:"size:255"`
+	StatusID      int
+	Status        ExhibitStatus `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	// PublishAt and UnpublishAt limit when an exhibit in a public status is
+	// visible to guests
+	PublishAt   *time.Time `gorm:"index"`
+	UnpublishAt *time.Time `gorm:"index"`
+	// AnnouncedAt is set once saved searches were notified of the exhibit
+	// being visible to guests, publishing it again resets it
+	AnnouncedAt *time.Time `gorm:"index" json:"-"`
+	// Views counts how often the exhibit was opened by others than its
+	// author and admins, it is only changed by GetExhibit
+	Views      int64 `gorm:"not null;default:0;index;<-:false"`
+	AuthorID   int
+	Author     User  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	Tags       []Tag `gorm:"many2many:exhibit_tags;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
+	AssetURL   string         `gorm:"-"`
+	PreviewURL string         `gorm:"-"`
+	// LatestDecision is only filled in for the author and admins
+	LatestDecision *ModerationDecision `gorm:"-" json:",omitempty"`
+	// Highlight is filled in for search results
+	Highlight *SearchHighlight `gorm:"-" json:",omitempty"`
+	// SearchVector is maintained by database triggers, see the search package
+	SearchVector string `gorm:"type:tsvector;index:,type:gin;->:false" json:"-"`
+	// Metadata is read from the asset on upload, it is nil when the format
+	// has none that could be decoded
+	Metadata *MediaMetadata `gorm:"type:jsonb;serializer:json" json:",omitempty"`
+
+	// The approved versions of replaced files are kept here until the
+	// replacement is approved
+	PreviousAssetPath     string         `gorm:"size:255" json:"-"`
+	PreviousPreviewPath   string         `gorm:"size:255" json:"-"`
+	PreviousMimeType      string         `gorm:"size:255" json:"-"`
+	PreviousThumbnailPath string         `gorm:"size:255" json:"-"`
+	PreviousCardPath      string         `gorm:"size:255" json:"-"`
+	PreviousFullPath      string         `gorm:"size:255" json:"-"`
+	PreviousMetadata      *MediaMetadata `gorm:"type:jsonb;serializer:json" json:"-"`
+}
+
+// FileKeys returns the storage keys of every file the exhibit references,
+// including the previous versions kept during moderation
+func (e Exhibit) FileKeys() []string {
+	var keys []string
+	for _, key := range []string{
+		e.AssetPath, e.PreviewPath, e.ThumbnailPath, e.CardPath, e.FullPath,
+		e.PreviousAssetPath, e.PreviousPreviewPath, e.PreviousThumbnailPath, e.PreviousCardPath, e.PreviousFullPath,
+	} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Where the preview of exhibits of a type comes from
+const (
+	// PreviewFromAsset uses the asset itself, it has to be an image
+	PreviewFromAsset = "asset"
+	// PreviewRequired needs a preview photo with every upload
+	PreviewRequired = "required"
+	// PreviewGenerated generates the preview from the asset when no preview
+	// photo is uploaded
+	PreviewGenerated = "generated"
+)
+
+// SearchHighlight holds HTML snippets of an exhibit with the words of a
+// search query wrapped in <mark> tags
+type SearchHighlight struct {
+	Title       string
+	Description string
+}
+
+// MediaMetadata describes the content of an asset. Which fields are set
+// depends on the format: photos have the camera, capture time and location
+// from EXIF, audio the ID3 tags and videos their dimensions and creation
+// time. The JSON keys are used by the filters of the exhibit listing.
+type MediaMetadata struct {
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Duration is in seconds
+	Duration float64 `json:"duration,omitempty"`
+	// Bitrate is in bits per second
+	Bitrate     int        `json:"bitrate,omitempty"`
+	CameraMake  string     `json:"camera_make,omitempty"`
+	CameraModel string     `json:"camera_model,omitempty"`
+	CapturedAt  *time.Time `json:"captured_at,omitempty"`
+	Latitude    *float64   `json:"latitude,omitempty"`
+	Longitude   *float64   `json:"longitude,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Artist      string     `json:"artist,omitempty"`
+	Album       string     `json:"album,omitempty"`
+	Year        string     `json:"year,omitempty"`
 }
 
 type ExhibitType struct {
 	ID   int    `gorm:"primaryKey"`
 	Name string `gorm:"size:255;not null;unique" json:"name"`
+	// AllowedMimeTypes lists the content types accepted for the asset, any
+	// content is accepted when it is empty
+	AllowedMimeTypes []string `gorm:"serializer:json" json:"allowed_mime_types"`
+	// MaxSize limits the size of the asset in bytes, 0 means no limit
+	MaxSize int64 `gorm:"not null;default:0" json:"max_size"`
+	// Preview is one of the Preview constants, types without one require a
+	// preview photo
+	Preview string `gorm:"size:16" json:"preview"`
+}
+
+// AllowedTypes returns the allow-list of the type in the form media.Check
+// expects, nil when any content is accepted
+func (t ExhibitType) AllowedTypes() []string {
+	if len(t.AllowedMimeTypes) == 0 {
+		return nil
+	}
+	return t.AllowedMimeTypes
 }
 
 type ExhibitStatus struct {
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// ModerationDecision records a workflow transition of an exhibit, such as an
+// admin approving or rejecting it or the author editing it
+type ModerationDecision struct {
+	ID         int     `gorm:"primaryKey"`
+	ExhibitID  int     `gorm:"not null;index"`
+	Exhibit    Exhibit `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	ReviewerID int
+	Reviewer   User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	StatusID   int
+	Status     ExhibitStatus `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	Reason     string        `gorm:"size:1024"`
+	CreatedAt  time.Time
+}
+
+// ModerationClaim locks an exhibit for one reviewer until ExpiresAt, so two
+// admins don't review the same exhibit
+type ModerationClaim struct {
+	ExhibitID  int       `gorm:"primaryKey;autoIncrement:false"`
+	Exhibit    Exhibit   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	ReviewerID int       `gorm:"not null"`
+	Reviewer   User      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	ExpiresAt  time.Time `gorm:"not null;index"`
+	CreatedAt  time.Time
+}
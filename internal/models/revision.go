@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// ExhibitRevision is a snapshot of an exhibit taken before it was changed.
+// It holds references on its files so the exhibit can be rolled back to it.
+type ExhibitRevision struct {
+	ID            int     `gorm:"primaryKey"`
+	ExhibitID     int     `gorm:"not null;index"`
+	Exhibit       Exhibit `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Title         string  `gorm:"size:255;not null"`
+	TypeID        int
+	Type          ExhibitType    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	Description   string         `gorm:"size:255"`
+	AssetPath     string         `gorm:"size:255;not null"`
+	PreviewPath   string         `gorm:"size:255;not null"`
+	MimeType      string         `gorm:"size:255"`
+	Metadata      *MediaMetadata `gorm:"type:jsonb;serializer:json" json:",omitempty"`
+	ThumbnailPath string         `gorm:"size:255"`
+	CardPath      string         `gorm:"size:255"`
+	FullPath      string         `gorm:"size:255"`
+	StatusID      int
+	Status        ExhibitStatus `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	// AuthorID is the user whose change replaced this version
+	AuthorID  int
+	Author    User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	CreatedAt time.Time
+}
+
+// FileKeys returns the storage keys of the files the revision references
+func (r ExhibitRevision) FileKeys() []string {
+	var keys []string
+	for _, key := range []string{r.AssetPath, r.PreviewPath, r.ThumbnailPath, r.CardPath, r.FullPath} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// SavedSearch is a named set of exhibit listing filters of a user. Empty
+// filters match every exhibit.
+type SavedSearch struct {
+	ID        int    `gorm:"primaryKey"`
+	UserID    int    `gorm:"not null;index"`
+	User      User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Name      string `gorm:"size:255;not null"`
+	TypeID    *int
+	Type      *ExhibitType `gorm:"constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;" json:",omitempty"`
+	Title     string       `gorm:"size:255"`
+	Username  string       `gorm:"size:255"`
+	StartDate *time.Time
+	EndDate   *time.Time
+	CreatedAt time.Time
+}
+
+// Notification tells a user that an exhibit matching one of their saved
+// searches was published
+type Notification struct {
+	ID            int          `gorm:"primaryKey"`
+	UserID        int          `gorm:"not null;index"`
+	User          User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	SavedSearchID *int         `gorm:"uniqueIndex:idx_notifications_search_exhibit"`
+	SavedSearch   *SavedSearch `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:",omitempty"`
+	ExhibitID     int          `gorm:"not null;uniqueIndex:idx_notifications_search_exhibit"`
+	Exhibit       Exhibit      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	ReadAt        *time.Time
+	CreatedAt     time.Time
+}
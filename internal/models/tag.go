@@ -0,0 +1,15 @@
+package models
+
+// Tag is a free-form label on exhibits. Names are stored normalised, see
+// the tag parsing in the handlers.
+type Tag struct {
+	ID   int    `gorm:"primaryKey"`
+	Name string `gorm:"size:64;not null;unique" json:"name"`
+}
+
+// TagCount is an entry of the tag cloud
+type TagCount struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
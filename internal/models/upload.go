@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Upload is a resumable upload in progress. The received bytes are kept in
+// a file named after ID in the uploads directory.
+type Upload struct {
+	ID        string    `gorm:"primaryKey;size:64"`
+	UserID    int       `gorm:"not null;index"`
+	User      User      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Length    int64     `gorm:"not null"`
+	Offset    int64     `gorm:"not null;default:0"`
+	Metadata  string    `gorm:"size:2048"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,174 @@
+package search
+
+import (
+	"fmt"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Config is the text search configuration. simple doesn't stem words, so it
+// treats every language the same and prefix matching makes up for it.
+const Config = "simple"
+
+// maxWords caps the number of words of a query
+const maxWords = 16
+
+// Matches are wrapped in these private use characters by ts_headline, so the
+// snippets can be escaped before they are marked up
+const (
+	startSel = "\ue000"
+	stopSel  = "\ue001"
+)
+
+// triggers keep exhibits.search_vector up to date. The title weighs most,
+// then the tags, the description and the username of the author. Changes to
+// tags and users reset the vector, which makes the exhibit trigger rebuild it.
+var triggers = []string{
+	`CREATE OR REPLACE FUNCTION exhibits_search_vector_update() RETURNS trigger AS $$
+	BEGIN
+		NEW.search_vector :=
+			setweight(to_tsvector('` + Config + `', coalesce(NEW.title, '')), 'A') ||
+			setweight(to_tsvector('` + Config + `', coalesce((
+				SELECT string_agg(tags.name, ' ') FROM exhibit_tags
+				JOIN tags ON tags.id = exhibit_tags.tag_id
+				WHERE exhibit_tags.exhibit_id = NEW.id), '')), 'B') ||
+			setweight(to_tsvector('` + Config + `', coalesce(NEW.description, '')), 'C') ||
+			setweight(to_tsvector('` + Config + `', coalesce((
+				SELECT username FROM users WHERE users.id = NEW.author_id), '')), 'D');
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS exhibits_search_vector ON exhibits`,
+	`CREATE TRIGGER exhibits_search_vector
+		BEFORE INSERT OR UPDATE OF title, description, author_id, search_vector ON exhibits
+		FOR EACH ROW EXECUTE FUNCTION exhibits_search_vector_update()`,
+
+	`CREATE OR REPLACE FUNCTION exhibit_tags_search_vector_update() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			UPDATE exhibits SET search_vector = NULL WHERE id = OLD.exhibit_id;
+		ELSE
+			UPDATE exhibits SET search_vector = NULL WHERE id = NEW.exhibit_id;
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS exhibit_tags_search_vector ON exhibit_tags`,
+	`CREATE TRIGGER exhibit_tags_search_vector
+		AFTER INSERT OR DELETE ON exhibit_tags
+		FOR EACH ROW EXECUTE FUNCTION exhibit_tags_search_vector_update()`,
+
+	`CREATE OR REPLACE FUNCTION tags_search_vector_update() RETURNS trigger AS $$
+	BEGIN
+		UPDATE exhibits SET search_vector = NULL
+		WHERE id IN (SELECT exhibit_id FROM exhibit_tags WHERE tag_id = NEW.id);
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS tags_search_vector ON tags`,
+	`CREATE TRIGGER tags_search_vector
+		AFTER UPDATE OF name ON tags
+		FOR EACH ROW EXECUTE FUNCTION tags_search_vector_update()`,
+
+	`CREATE OR REPLACE FUNCTION users_search_vector_update() RETURNS trigger AS $$
+	BEGIN
+		UPDATE exhibits SET search_vector = NULL WHERE author_id = NEW.id;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS users_search_vector ON users`,
+	`CREATE TRIGGER users_search_vector
+		AFTER UPDATE OF username ON users
+		FOR EACH ROW EXECUTE FUNCTION users_search_vector_update()`,
+
+	// Fill in exhibits created before the search was added
+	`UPDATE exhibits SET search_vector = NULL WHERE search_vector IS NULL`,
+}
+
+// Migrate installs the triggers maintaining the search vector of exhibits.
+// It must run after the exhibits, tags and users tables are migrated.
+func Migrate(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, sql := range triggers {
+			if err := tx.Exec(sql).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ParseQuery turns user input into a tsquery matching exhibits that contain
+// every word, each as a prefix. It returns an empty string when q has no
+// words. Only letters and digits are kept, so q can't inject tsquery syntax.
+func ParseQuery(q string) string {
+	words := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > maxWords {
+		words = words[:maxWords]
+	}
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// Matching limits a query on exhibits to the ones matching tsquery, sort
+// them by Rank to get the most relevant first
+func Matching(tsquery string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("exhibits.search_vector @@ to_tsquery('"+Config+"', ?)", tsquery)
+	}
+}
+
+// Rank returns the SQL expression ranking exhibits by relevance to tsquery,
+// which must come from ParseQuery. The query is inlined, which is safe as
+// ParseQuery only lets letters, digits and its own operators through.
+func Rank(tsquery string) string {
+	return "ts_rank(exhibits.search_vector, to_tsquery('" + Config + "', '" + tsquery + "'))"
+}
+
+// Highlights returns the title and description of the exhibits with ids
+// with the words of tsquery marked up, keyed by exhibit ID. The snippets are
+// HTML escaped and matches are wrapped in <mark> tags.
+func Highlights(db *gorm.DB, tsquery string, ids []int) (map[int]models.SearchHighlight, error) {
+	highlights := map[int]models.SearchHighlight{}
+	if len(ids) == 0 {
+		return highlights, nil
+	}
+
+	options := "StartSel=" + startSel + ", StopSel=" + stopSel
+	headline := "ts_headline('" + Config + "', %s, to_tsquery('" + Config + "', ?), ?) AS %[1]s"
+	var rows []struct {
+		ID          int
+		Title       string
+		Description string
+	}
+	if err := db.Table("exhibits").
+		Select("id, "+fmt.Sprintf(headline, "title")+", "+fmt.Sprintf(headline, "description"),
+			tsquery, options+", HighlightAll=true",
+			tsquery, options+", MaxFragments=2, MinWords=5, MaxWords=20").
+		Where("id IN ?", ids).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		highlights[row.ID] = models.SearchHighlight{
+			Title:       markUp(row.Title),
+			Description: markUp(row.Description),
+		}
+	}
+	return highlights, nil
+}
+
+// markUp escapes a ts_headline snippet and turns its selection markers into <mark> tags
+func markUp(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, startSel, "<mark>")
+	return strings.ReplaceAll(snippet, stopSel, "</mark>")
+}
@@ -0,0 +1,50 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"", ""},
+		{"  ", ""},
+		{"!&|:*()'", ""},
+		{"Cat", "cat:*"},
+		{"black cat", "black:* & cat:*"},
+		{"  black,   CAT!  ", "black:* & cat:*"},
+		{"Київ 2024", "київ:* & 2024:*"},
+		{"cat' & !dog:*", "cat:* & dog:*"},
+		{"o'reilly", "o:* & reilly:*"},
+		{"a) | (b", "a:* & b:*"},
+		{strings.Repeat("w ", 20), strings.TrimSuffix(strings.Repeat("w:* & ", maxWords), " & ")},
+	}
+	for _, tt := range tests {
+		if got := ParseQuery(tt.q); got != tt.want {
+			t.Errorf("ParseQuery(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestMarkUp(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"a " + startSel + "cat" + stopSel + " sat", "a <mark>cat</mark> sat"},
+		{startSel + "one" + stopSel + " and " + startSel + "two" + stopSel, "<mark>one</mark> and <mark>two</mark>"},
+		{"<script>" + startSel + "x" + stopSel + "</script>", "&lt;script&gt;<mark>x</mark>&lt;/script&gt;"},
+		{`"Tom" & 'Jerry'`, "&#34;Tom&#34; &amp; &#39;Jerry&#39;"},
+		{"<mark>fake</mark>", "&lt;mark&gt;fake&lt;/mark&gt;"},
+	}
+	for _, tt := range tests {
+		if got := markUp(tt.snippet); got != tt.want {
+			t.Errorf("markUp(%q) = %q, want %q", tt.snippet, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// RangeGetter is implemented by backends that can read an object from an offset
+type RangeGetter interface {
+	GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+}
+
+// Open returns a seekable reader for key, so it can be used with
+// http.ServeContent. Backends that implement RangeGetter only fetch the
+// requested part of the object; otherwise seeking reads and discards data.
+func Open(ctx context.Context, s Storage, key string, size int64) (io.ReadSeekCloser, error) {
+	if _, err := cleanKey(key); err != nil {
+		return nil, err
+	}
+	return &seeker{ctx: ctx, s: s, key: key, size: size}, nil
+}
+
+type seeker struct {
+	ctx  context.Context
+	s    Storage
+	key  string
+	size int64
+	pos  int64
+	body io.ReadCloser
+	// bodyPos is the offset body is currently at
+	bodyPos int64
+}
+
+func (r *seeker) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil || r.bodyPos != r.pos {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.body.Read(p)
+	r.pos += int64(n)
+	r.bodyPos = r.pos
+	return n, err
+}
+
+func (r *seeker) open() error {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+
+	if rg, ok := r.s.(RangeGetter); ok {
+		body, err := rg.GetRange(r.ctx, r.key, r.pos)
+		if err != nil {
+			return err
+		}
+		r.body, r.bodyPos = body, r.pos
+		return nil
+	}
+
+	body, err := r.s.Get(r.ctx, r.key)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(io.Discard, body, r.pos); err != nil {
+		body.Close()
+		return err
+	}
+	r.body, r.bodyPos = body, r.pos
+	return nil
+}
+
+func (r *seeker) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.size + offset
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *seeker) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// memory is a Storage without GetRange, so the seeker has to skip data
+type memory struct {
+	objects map[string][]byte
+	gets    int
+}
+
+func (m *memory) Put(_ context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	m.objects[key] = data
+	return err
+}
+
+func (m *memory) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	m.gets++
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memory) Delete(_ context.Context, key string) error {
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memory) Stat(_ context.Context, key string) (ObjectInfo, error) {
+	data, ok := m.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return ObjectInfo{Key: key, Size: int64(len(data))}, nil
+}
+
+func (m *memory) List(context.Context, string) ([]ObjectInfo, error) {
+	return nil, nil
+}
+
+func (m *memory) URL(key string) string {
+	return "/" + key
+}
+
+func TestOpen(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+
+	local, err := NewLocal(t.TempDir(), "/storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends := map[string]Storage{
+		"local":  local,
+		"memory": &memory{objects: map[string][]byte{}},
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		n      int
+		want   string
+	}{
+		{"start", 0, io.SeekStart, 4, "0123"},
+		{"from start", 10, io.SeekStart, 3, "abc"},
+		{"from end", -3, io.SeekEnd, 3, "hij"},
+		{"past end", 5, io.SeekEnd, 3, ""},
+		{"to end", 15, io.SeekStart, 10, "fghij"},
+	}
+	for name, s := range backends {
+		if err := s.Put(context.Background(), "a/file.txt", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			r, err := Open(context.Background(), s, "a/file.txt", int64(len(data)))
+			if err != nil {
+				t.Fatalf("%s/%s: Open() error = %v", name, tt.name, err)
+			}
+			if _, err = r.Seek(tt.offset, tt.whence); err != nil {
+				t.Fatalf("%s/%s: Seek() error = %v", name, tt.name, err)
+			}
+			got, err := io.ReadAll(io.LimitReader(r, int64(tt.n)))
+			if err != nil {
+				t.Fatalf("%s/%s: Read() error = %v", name, tt.name, err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("%s/%s: read %q, want %q", name, tt.name, got, tt.want)
+			}
+			r.Close()
+		}
+	}
+}
+
+func TestOpenSeekCurrent(t *testing.T) {
+	s := &memory{objects: map[string][]byte{"file": []byte("0123456789")}}
+	r, err := Open(context.Background(), s, "file", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	buf := make([]byte, 2)
+	for _, step := range []struct {
+		seek int64
+		want string
+	}{
+		{0, "01"},
+		{0, "23"},
+		{2, "67"},
+		{-6, "23"},
+	} {
+		if _, err = r.Seek(step.seek, io.SeekCurrent); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.ReadFull(r, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != step.want {
+			t.Errorf("after seeking %d: read %q, want %q", step.seek, buf, step.want)
+		}
+	}
+	// Reading on from where the body is must not fetch the object again
+	if s.gets != 3 {
+		t.Errorf("object fetched %d times, want 3", s.gets)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	s := &memory{objects: map[string][]byte{}}
+	if _, err := Open(context.Background(), s, "../secret", 10); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Open() with a traversal key error = %v, want %v", err, ErrInvalidKey)
+	}
+
+	r, err := Open(context.Background(), s, "missing", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(make([]byte, 1)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read() of a missing object error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err = r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek() to a negative position succeeded")
+	}
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCleanKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+		err  error
+	}{
+		{key: "users/default.png", want: "users/default.png"},
+		{key: "/users/default.png", want: "users/default.png"},
+		{key: "exhibits/2024/file.mp4", want: "exhibits/2024/file.mp4"},
+		{key: "", err: ErrInvalidKey},
+		{key: "/", err: ErrInvalidKey},
+		{key: ".", err: ErrInvalidKey},
+		{key: "..", err: ErrInvalidKey},
+		{key: "../etc/passwd", err: ErrInvalidKey},
+		{key: "users/../../etc/passwd", err: ErrInvalidKey},
+		{key: "users/../default.png", err: ErrInvalidKey},
+		{key: "users/./default.png", err: ErrInvalidKey},
+		{key: "users//default.png", err: ErrInvalidKey},
+		{key: "users/", err: ErrInvalidKey},
+		{key: "..\\etc\\passwd", err: ErrInvalidKey},
+		{key: "users\\default.png", err: ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		got, err := cleanKey(tt.key)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("cleanKey(%q) error = %v, want %v", tt.key, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("cleanKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package trash
+
+import (
+	"context"
+	"github.com/seemsod1/ancy/internal/assets"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Report counts the rows removed by Purge
+type Report struct {
+	Exhibits int
+	Users    int
+}
+
+// Purge permanently deletes exhibits and users that were moved to the trash
+// before cutoff and releases the files they and the exhibit revisions hold
+func Purge(ctx context.Context, db *gorm.DB, store *assets.Store, cutoff time.Time) (Report, error) {
+	var report Report
+	db = db.WithContext(ctx)
+
+	var exhibits []models.Exhibit
+	if err := db.Unscoped().Where("deleted_at < ?", cutoff).Find(&exhibits).Error; err != nil {
+		return report, err
+	}
+	for _, exhibit := range exhibits {
+		var revisions []models.ExhibitRevision
+		if err := db.Where("exhibit_id = ?", exhibit.ID).Find(&revisions).Error; err != nil {
+			return report, err
+		}
+		keys := exhibit.FileKeys()
+		for _, revision := range revisions {
+			keys = append(keys, revision.FileKeys()...)
+		}
+
+		// Revisions, decisions and claims go with the exhibit
+		if err := db.Unscoped().Delete(&exhibit).Error; err != nil {
+			return report, err
+		}
+		if err := release(ctx, store, keys); err != nil {
+			return report, err
+		}
+		report.Exhibits++
+	}
+
+	var users []models.User
+	if err := db.Unscoped().Where("deleted_at < ?", cutoff).Find(&users).Error; err != nil {
+		return report, err
+	}
+	for _, user := range users {
+		// Exhibits need their author, users whose exhibits are still kept
+		// wait for them
+		var exhibitCount int64
+		if err := db.Unscoped().Model(&models.Exhibit{}).Where("author_id = ?", user.ID).Count(&exhibitCount).Error; err != nil {
+			return report, err
+		}
+		if exhibitCount > 0 {
+			continue
+		}
+		if err := db.Unscoped().Delete(&user).Error; err != nil {
+			return report, err
+		}
+		if user.ProfilePhotoPath != "" && "users/"+user.ProfilePhotoPath != assets.DefaultProfilePhoto {
+			if err := store.Release(ctx, "users/"+user.ProfilePhotoPath); err != nil {
+				return report, err
+			}
+		}
+		report.Users++
+	}
+
+	return report, nil
+}
+
+func release(ctx context.Context, store *assets.Store, keys []string) error {
+	for _, key := range keys {
+		if err := store.Release(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package trash
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/seemsod1/ancy/internal/assets"
+	"github.com/seemsod1/ancy/internal/storage"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expectRelease expects Release of an untracked key, which deletes the file
+func expectRelease(mock sqlmock.Sqlmock, key string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "assets" WHERE key = \$1 LIMIT \$2 FOR UPDATE`).
+		WithArgs(key, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}))
+	mock.ExpectCommit()
+}
+
+func TestPurge(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := storage.NewLocal(t.TempDir(), "/storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	keys := []string{"exhibits/asset.png", "exhibits/old.png", "exhibits/kept.png", "users/photo.png"}
+	for _, key := range keys {
+		if err = local.Put(ctx, key, strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Exhibits go first and take the files of their revisions with them
+	mock.ExpectQuery(`SELECT \* FROM "exhibits" WHERE deleted_at < \$1`).
+		WithArgs(cutoff).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "author_id", "asset_path"}).AddRow(1, 7, "exhibits/asset.png"))
+	mock.ExpectQuery(`SELECT \* FROM "exhibit_revisions" WHERE exhibit_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exhibit_id", "asset_path"}).AddRow(1, 1, "exhibits/old.png"))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "exhibits" WHERE "exhibits"."id" = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectRelease(mock, "exhibits/asset.png")
+	expectRelease(mock, "exhibits/old.png")
+
+	// Users whose exhibits are kept wait for them, the default profile
+	// photo stays
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE deleted_at < \$1`).
+		WithArgs(cutoff).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "profile_photo_path"}).
+			AddRow(7, "").
+			AddRow(8, "photo.png").
+			AddRow(9, "default.png"))
+	for _, user := range []struct{ id, exhibits int }{{7, 1}, {8, 0}, {9, 0}} {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "exhibits" WHERE author_id = \$1`).
+			WithArgs(user.id).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(user.exhibits))
+		if user.exhibits > 0 {
+			continue
+		}
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "users" WHERE "users"."id" = \$1`).
+			WithArgs(user.id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if user.id == 8 {
+			expectRelease(mock, "users/photo.png")
+		}
+	}
+
+	report, err := Purge(ctx, db, assets.NewStore(db, local), cutoff)
+	if err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if want := (Report{Exhibits: 1, Users: 2}); report != want {
+		t.Errorf("Purge() = %+v, want %+v", report, want)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	for _, key := range keys {
+		_, err := local.Stat(ctx, key)
+		if kept := err == nil; kept != (key == "exhibits/kept.png") {
+			t.Errorf("%s kept = %v", key, kept)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package uploads
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/gorm"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for unknown or expired uploads
+	ErrNotFound = errors.New("uploads: upload not found")
+	// ErrOffsetMismatch is returned when a chunk doesn't start at the current offset
+	ErrOffsetMismatch = errors.New("uploads: offset mismatch")
+	// ErrTooLarge is returned when a chunk goes past the declared length
+	ErrTooLarge = errors.New("uploads: upload exceeds declared length")
+)
+
+// Manager keeps partial uploads on disk and their state in the database
+type Manager struct {
+	DB  *gorm.DB
+	Dir string
+	// TTL is how long an upload is kept after its last chunk
+	TTL time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewManager(db *gorm.DB, dir string, ttl time.Duration) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Manager{
+		DB:    db,
+		Dir:   dir,
+		TTL:   ttl,
+		locks: make(map[string]*sync.Mutex),
+	}, nil
+}
+
+func (m *Manager) path(id string) string {
+	return filepath.Join(m.Dir, id)
+}
+
+// lock serializes chunks written to the same upload
+func (m *Manager) lock(id string) func() {
+	m.mu.Lock()
+	l, ok := m.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		m.locks[id] = l
+	}
+	m.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// Create starts an upload of length bytes for userID
+func (m *Manager) Create(ctx context.Context, userID int, length int64, metadata string) (models.Upload, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return models.Upload{}, err
+	}
+
+	upload := models.Upload{
+		ID:        hex.EncodeToString(id[:]),
+		UserID:    userID,
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: time.Now().Add(m.TTL),
+	}
+
+	f, err := os.OpenFile(m.path(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	if err = f.Close(); err != nil {
+		return models.Upload{}, err
+	}
+
+	if err = m.DB.WithContext(ctx).Create(&upload).Error; err != nil {
+		_ = os.Remove(m.path(upload.ID))
+		return models.Upload{}, err
+	}
+
+	return upload, nil
+}
+
+// Get returns the unexpired upload id owned by userID
+func (m *Manager) Get(ctx context.Context, id string, userID int) (models.Upload, error) {
+	var upload models.Upload
+	err := m.DB.WithContext(ctx).Where("id = ? AND user_id = ? AND expires_at > ?", id, userID, time.Now()).Take(&upload).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Upload{}, ErrNotFound
+	}
+	return upload, err
+}
+
+// Append writes the chunk in r at offset. Whatever was received is kept
+// even when r fails midway, so the client can resume from the new offset.
+func (m *Manager) Append(ctx context.Context, upload *models.Upload, offset int64, r io.Reader) error {
+	unlock := m.lock(upload.ID)
+	defer unlock()
+
+	// Reload under the lock, a concurrent chunk may have moved the offset
+	if err := m.DB.WithContext(ctx).Take(upload, "id = ?", upload.ID).Error; err != nil {
+		return err
+	}
+	if offset != upload.Offset {
+		return ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(m.path(upload.ID), os.O_WRONLY, 0644)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Drop bytes of a previous chunk that were written but not recorded
+	if err = f.Truncate(upload.Offset); err != nil {
+		return err
+	}
+	if _, err = f.Seek(upload.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	remaining := upload.Length - upload.Offset
+	n, copyErr := io.Copy(f, io.LimitReader(r, remaining+1))
+	if n > remaining {
+		n = remaining
+		copyErr = ErrTooLarge
+		if err = f.Truncate(upload.Length); err != nil {
+			return err
+		}
+	}
+
+	upload.Offset += n
+	upload.ExpiresAt = time.Now().Add(m.TTL)
+	if err = m.DB.WithContext(ctx).Model(upload).Updates(map[string]interface{}{
+		"offset":     upload.Offset,
+		"expires_at": upload.ExpiresAt,
+	}).Error; err != nil {
+		return err
+	}
+
+	return copyErr
+}
+
+// Open opens the data of a completed upload
+func (m *Manager) Open(upload models.Upload) (*os.File, error) {
+	return os.Open(m.path(upload.ID))
+}
+
+// Remove deletes the upload and its data
+func (m *Manager) Remove(ctx context.Context, upload models.Upload) error {
+	if err := m.DB.WithContext(ctx).Delete(&upload).Error; err != nil {
+		return err
+	}
+	if err := os.Remove(m.path(upload.ID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	m.mu.Lock()
+	delete(m.locks, upload.ID)
+	m.mu.Unlock()
+
+	return nil
+}
+
+// RemoveExpired deletes all uploads past their expiry and returns how many were removed
+func (m *Manager) RemoveExpired(ctx context.Context) (int, error) {
+	var expired []models.Upload
+	if err := m.DB.WithContext(ctx).Where("expires_at <= ?", time.Now()).Find(&expired).Error; err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, upload := range expired {
+		if err := m.Remove(ctx, upload); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// Run removes expired uploads every interval until ctx is done
+func (m *Manager) Run(ctx context.Context, interval time.Duration, errorLog *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := m.RemoveExpired(ctx); err != nil {
+				errorLog.Println("failed to remove expired uploads:", err)
+			}
+		}
+	}
+}
+
+// ParseMetadata decodes a tus Upload-Metadata header, a comma separated list
+// of keys each followed by a space and the base64 encoded value
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("uploads: empty metadata key")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,125 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"github.com/seemsod1/ancy/internal/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{header: "", want: map[string]string{}},
+		{header: "  ", want: map[string]string{}},
+		{header: "filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==", want: map[string]string{"filename": "world_domination_plan.pdf"}},
+		{header: "title 0JrQvtGC, type Mg==", want: map[string]string{"title": "Кот", "type": "2"}},
+		{header: "is_confidential", want: map[string]string{"is_confidential": ""}},
+		{header: "title dGVzdA==,", wantErr: true},
+		{header: "title dGVzdA==,,type Mg==", wantErr: true},
+		{header: "title not base64!", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMetadata(tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMetadata(%q) error = %v, wantErr %v", tt.header, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMetadata(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// failingReader returns data and then fails, like a dropped connection
+type failingReader struct {
+	data string
+}
+
+var errDropped = errors.New("connection dropped")
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errDropped
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// dryRunManager keeps uploads in dir. Its database only builds statements,
+// so Append works on the upload it is given.
+func dryRunManager(t *testing.T) *Manager {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(db, t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing is what the upload file holds, offset how much of it was recorded
+		existing   string
+		offset     int64
+		length     int64
+		at         int64
+		chunk      io.Reader
+		wantOffset int64
+		wantErr    error
+		wantData   string
+	}{
+		{"first chunk", "", 0, 10, 0, strings.NewReader("01234"), 5, nil, "01234"},
+		{"next chunk", "01234", 5, 10, 5, strings.NewReader("56789"), 10, nil, "0123456789"},
+		{"empty chunk", "01234", 5, 10, 5, strings.NewReader(""), 5, nil, "01234"},
+		{"offset behind", "01234", 5, 10, 3, strings.NewReader("34"), 5, ErrOffsetMismatch, "01234"},
+		{"offset ahead", "01234", 5, 10, 7, strings.NewReader("78"), 5, ErrOffsetMismatch, "01234"},
+		{"oversize chunk", "01234", 5, 10, 5, strings.NewReader("56789abc"), 10, ErrTooLarge, "0123456789"},
+		{"oversize first chunk", "", 0, 3, 0, strings.NewReader("0123"), 3, ErrTooLarge, "012"},
+		{"unrecorded bytes", "01234xx", 5, 10, 5, strings.NewReader("56"), 7, nil, "0123456"},
+		{"dropped connection", "", 0, 10, 0, &failingReader{data: "012"}, 3, errDropped, "012"},
+	}
+	for _, tt := range tests {
+		m := dryRunManager(t)
+		upload := &models.Upload{ID: "upload", Length: tt.length, Offset: tt.offset}
+		if err := os.WriteFile(m.path(upload.ID), []byte(tt.existing), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := m.Append(context.Background(), upload, tt.at, tt.chunk)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Append() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if upload.Offset != tt.wantOffset {
+			t.Errorf("%s: offset after Append() = %d, want %d", tt.name, upload.Offset, tt.wantOffset)
+		}
+		data, _ := os.ReadFile(m.path(upload.ID))
+		if string(data) != tt.wantData {
+			t.Errorf("%s: file holds %q, want %q", tt.name, data, tt.wantData)
+		}
+	}
+}
+
+func TestAppendMissingFile(t *testing.T) {
+	m := dryRunManager(t)
+	upload := &models.Upload{ID: "gone", Length: 10}
+	if err := m.Append(context.Background(), upload, 0, strings.NewReader("0")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Append() error = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,188 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// RoleAuthor stands for the author of the exhibit in Transition.Roles, next
+// to the names of user roles
+const RoleAuthor = "Author"
+
+// What a transition does with the files an edit replaced
+const (
+	// FilesKeep leaves replaced files waiting for a later decision
+	FilesKeep = ""
+	// FilesAccept drops the previous versions of replaced files
+	FilesAccept = "accept"
+	// FilesRestore puts the previous versions of replaced files back
+	FilesRestore = "restore"
+)
+
+var (
+	ErrUnknownTransition = errors.New("workflow: unknown transition")
+	ErrNotAllowed        = errors.New("workflow: transition is not allowed from this status")
+	ErrForbidden         = errors.New("workflow: transition is not allowed for this role")
+)
+
+// State is an exhibit status
+type State struct {
+	Name string `json:"name"`
+	// Public states are visible to everyone, the others only to the author and admins
+	Public bool `json:"public"`
+	// Review states make up the moderation queue
+	Review bool `json:"review"`
+	ID     int  `json:"-"`
+}
+
+// Transition moves an exhibit from one of From to To
+type Transition struct {
+	Name  string   `json:"name"`
+	From  []string `json:"from"`
+	To    string   `json:"to"`
+	Roles []string `json:"roles"`
+	Files string   `json:"files"`
+}
+
+// Workflow lists the exhibit statuses and the transitions between them.
+// New exhibits start in Initial. Editing an exhibit performs the "edit"
+// transition when there is one from its status.
+type Workflow struct {
+	Initial     string       `json:"initial"`
+	States      []State      `json:"states"`
+	Transitions []Transition `json:"transitions"`
+}
+
+// Default is used when no workflow file is configured
+var Default = Workflow{
+	Initial: "Pending",
+	States: []State{
+		{Name: "Pending", Review: true},
+		{Name: "Approved", Public: true},
+		{Name: "Rejected"},
+	},
+	Transitions: []Transition{
+		{Name: "approve", From: []string{"Pending", "Rejected"}, To: "Approved", Roles: []string{"Admin"}, Files: FilesAccept},
+		{Name: "reject", From: []string{"Pending", "Approved"}, To: "Rejected", Roles: []string{"Admin"}, Files: FilesRestore},
+		{Name: "edit", From: []string{"Approved", "Rejected"}, To: "Pending", Roles: []string{RoleAuthor}},
+	},
+}
+
+// Load reads a workflow from the JSON file at path, or returns a copy of
+// Default when path is empty
+func Load(path string) (*Workflow, error) {
+	w := Default
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		w = Workflow{}
+		if err = json.Unmarshal(data, &w); err != nil {
+			return nil, fmt.Errorf("workflow: %s: %w", path, err)
+		}
+	}
+	w.States = slices.Clone(w.States)
+
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (w *Workflow) validate() error {
+	if _, ok := w.State(w.Initial); !ok {
+		return fmt.Errorf("workflow: unknown initial status %q", w.Initial)
+	}
+	for _, t := range w.Transitions {
+		if _, ok := w.State(t.To); !ok {
+			return fmt.Errorf("workflow: transition %q leads to unknown status %q", t.Name, t.To)
+		}
+		for _, from := range t.From {
+			if _, ok := w.State(from); !ok {
+				return fmt.Errorf("workflow: transition %q starts at unknown status %q", t.Name, from)
+			}
+		}
+		if t.Files != FilesKeep && t.Files != FilesAccept && t.Files != FilesRestore {
+			return fmt.Errorf("workflow: transition %q has unknown files mode %q", t.Name, t.Files)
+		}
+	}
+	return nil
+}
+
+// State returns the status called name
+func (w *Workflow) State(name string) (State, bool) {
+	for _, s := range w.States {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return State{}, false
+}
+
+// StateByID returns the status with the database ID id
+func (w *Workflow) StateByID(id int) (State, bool) {
+	for _, s := range w.States {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return State{}, false
+}
+
+// SetID records the database ID of the status called name
+func (w *Workflow) SetID(name string, id int) {
+	for i := range w.States {
+		if w.States[i].Name == name {
+			w.States[i].ID = id
+		}
+	}
+}
+
+// PublicIDs returns the IDs of the public statuses
+func (w *Workflow) PublicIDs() []int {
+	return w.ids(func(s State) bool { return s.Public })
+}
+
+// ReviewIDs returns the IDs of the statuses in the moderation queue
+func (w *Workflow) ReviewIDs() []int {
+	return w.ids(func(s State) bool { return s.Review })
+}
+
+func (w *Workflow) ids(keep func(State) bool) []int {
+	ids := []int{}
+	for _, s := range w.States {
+		if keep(s) {
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids
+}
+
+// Transition finds the transition called name from the status from that
+// one of roles may perform. It returns the transition and the role that
+// allowed it.
+func (w *Workflow) Transition(from, name string, roles ...string) (Transition, string, error) {
+	err := ErrUnknownTransition
+	for _, t := range w.Transitions {
+		if t.Name != name {
+			continue
+		}
+		if !slices.Contains(t.From, from) {
+			if err == ErrUnknownTransition {
+				err = ErrNotAllowed
+			}
+			continue
+		}
+		for _, role := range roles {
+			if slices.Contains(t.Roles, role) {
+				return t, role, nil
+			}
+		}
+		err = ErrForbidden
+	}
+	return Transition{}, "", err
+}
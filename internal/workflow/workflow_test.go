@@ -0,0 +1,135 @@
+package workflow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			json: `{"initial": "Draft", "states": [{"name": "Draft"}, {"name": "Published", "public": true}],
+				"transitions": [{"name": "publish", "from": ["Draft"], "to": "Published", "roles": ["Author"], "files": "accept"}]}`,
+		},
+		{
+			name:    "unknown initial",
+			json:    `{"initial": "Draft", "states": [{"name": "Published"}]}`,
+			wantErr: `unknown initial status "Draft"`,
+		},
+		{
+			name: "unknown target",
+			json: `{"initial": "Draft", "states": [{"name": "Draft"}],
+				"transitions": [{"name": "publish", "from": ["Draft"], "to": "Published"}]}`,
+			wantErr: `transition "publish" leads to unknown status "Published"`,
+		},
+		{
+			name: "unknown source",
+			json: `{"initial": "Draft", "states": [{"name": "Draft"}],
+				"transitions": [{"name": "edit", "from": ["Published"], "to": "Draft"}]}`,
+			wantErr: `transition "edit" starts at unknown status "Published"`,
+		},
+		{
+			name: "unknown files mode",
+			json: `{"initial": "Draft", "states": [{"name": "Draft"}],
+				"transitions": [{"name": "edit", "from": ["Draft"], "to": "Draft", "files": "drop"}]}`,
+			wantErr: `unknown files mode "drop"`,
+		},
+		{
+			name:    "malformed",
+			json:    `{"initial": `,
+			wantErr: "unexpected end of JSON input",
+		},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "workflow.json")
+		if err := os.WriteFile(path, []byte(tt.json), 0644); err != nil {
+			t.Fatal(err)
+		}
+		w, err := Load(path)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: Load() error = %v", tt.name, err)
+			} else if w.Initial != "Draft" || len(w.States) != 2 || len(w.Transitions) != 1 {
+				t.Errorf("%s: Load() = %+v", tt.name, w)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: Load() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load() of a missing file error = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestLoadDefault(t *testing.T) {
+	w, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if w.Initial != Default.Initial || len(w.States) != len(Default.States) {
+		t.Fatalf("Load() = %+v, want the default workflow", w)
+	}
+
+	// IDs are set per loaded workflow and must not leak into Default
+	w.SetID("Approved", 7)
+	if s, _ := Default.State("Approved"); s.ID != 0 {
+		t.Errorf("SetID() changed Default, Approved has ID %d", s.ID)
+	}
+	if ids := w.PublicIDs(); len(ids) != 1 || ids[0] != 7 {
+		t.Errorf("PublicIDs() = %v, want [7]", ids)
+	}
+}
+
+func TestTransition(t *testing.T) {
+	w := &Workflow{
+		Initial: "Pending",
+		States:  []State{{Name: "Pending"}, {Name: "Approved"}, {Name: "Rejected"}, {Name: "Archived"}},
+		Transitions: []Transition{
+			{Name: "approve", From: []string{"Pending"}, To: "Approved", Roles: []string{"Admin"}},
+			{Name: "withdraw", From: []string{"Pending"}, To: "Rejected", Roles: []string{RoleAuthor}},
+			{Name: "withdraw", From: []string{"Approved"}, To: "Archived", Roles: []string{"Admin"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		from     string
+		action   string
+		roles    []string
+		wantTo   string
+		wantRole string
+		wantErr  error
+	}{
+		{"allowed", "Pending", "approve", []string{"Admin"}, "Approved", "Admin", nil},
+		{"any role", "Pending", "approve", []string{RoleAuthor, "Admin"}, "Approved", "Admin", nil},
+		{"unknown", "Pending", "publish", []string{"Admin"}, "", "", ErrUnknownTransition},
+		{"wrong status", "Rejected", "approve", []string{"Admin"}, "", "", ErrNotAllowed},
+		{"wrong role", "Pending", "approve", []string{RoleAuthor}, "", "", ErrForbidden},
+		{"no roles", "Pending", "approve", nil, "", "", ErrForbidden},
+		{"same name by status", "Approved", "withdraw", []string{"Admin"}, "Archived", "Admin", nil},
+		{"same name other status", "Pending", "withdraw", []string{RoleAuthor}, "Rejected", RoleAuthor, nil},
+		{"same name wrong role", "Approved", "withdraw", []string{RoleAuthor}, "", "", ErrForbidden},
+		{"same name wrong status", "Rejected", "withdraw", []string{"Admin"}, "", "", ErrNotAllowed},
+	}
+	for _, tt := range tests {
+		got, role, err := w.Transition(tt.from, tt.action, tt.roles...)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Transition() error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got.To != tt.wantTo || role != tt.wantRole {
+			t.Errorf("%s: Transition() = %q by %q, want %q by %q", tt.name, got.To, role, tt.wantTo, tt.wantRole)
+		}
+	}
+}